@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/scchi/cards/internal/data"
+)
+
+type contextKey string
+
+const (
+	requestIDContextKey = contextKey("requestID")
+	userContextKey      = contextKey("user")
+	apiKeyContextKey    = contextKey("apiKey")
+	shareContextKey     = contextKey("share")
+	deckContextKey      = contextKey("deck")
+)
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID the requestID middleware gave the
+// request, or an empty string for requests that didn't pass through it.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the user the authenticate middleware put on the
+// request. Every route is behind authenticate, so a missing user is a bug.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was made with, or nil.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetShare(r *http.Request, share *data.ShareToken) *http.Request {
+	ctx := context.WithValue(r.Context(), shareContextKey, share)
+	return r.WithContext(ctx)
+}
+
+// contextGetShare returns the verified share token the request was made with,
+// or nil.
+func (app *application) contextGetShare(r *http.Request) *data.ShareToken {
+	share, _ := r.Context().Value(shareContextKey).(*data.ShareToken)
+	return share
+}
+
+func (app *application) contextSetDeck(r *http.Request, deck *data.Deck) *http.Request {
+	ctx := context.WithValue(r.Context(), deckContextKey, deck)
+	return r.WithContext(ctx)
+}
+
+// contextGetDeck returns the deck requireDeckOwner checked the request
+// against. Every route on a single deck is behind requireDeckOwner, so a
+// missing deck is a bug.
+func (app *application) contextGetDeck(r *http.Request) *data.Deck {
+	deck, ok := r.Context().Value(deckContextKey).(*data.Deck)
+	if !ok {
+		panic("missing deck value in request context")
+	}
+
+	return deck
+}
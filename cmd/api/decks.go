@@ -4,46 +4,102 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/scchi/cards/internal/data"
 	"github.com/scchi/cards/internal/validator"
 )
 
+// maxEditAttempts is how many times a handler that reads and then updates a
+// deck tries again after losing a race with another request.
+const maxEditAttempts = 3
+
 func (app *application) createDeckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var input struct {
-		Shuffled bool        `json:"shuffled"`
-		Cards    []data.Card `json:"cards"`
+		Shuffled         bool        `json:"shuffled"`
+		ShuffleAlgorithm string      `json:"shuffle_algorithm"`
+		DeckCount        *int        `json:"deck_count"`
+		Cards            []data.Card `json:"cards"`
+		TTL              *int        `json:"ttl"`
+		Labels           data.Labels `json:"labels"`
 	}
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.readJSONErrorResponse(w, r, err)
 		return
 	}
 
 	deck := &data.Deck{
-		Shuffled: input.Shuffled,
-		Cards:    input.Cards,
+		Shuffled:         input.Shuffled,
+		ShuffleAlgorithm: input.ShuffleAlgorithm,
+		DeckCount:        1,
+		Cards:            input.Cards,
+		Labels:           input.Labels,
 	}
 
-	if deck.Cards != nil {
-		v := validator.New()
+	if input.DeckCount != nil {
+		deck.DeckCount = *input.DeckCount
+	}
+
+	user := app.contextGetUser(r)
+
+	if !user.IsAnonymous() {
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		deck.OwnerID = &user.ID
+	}
+
+	if deck.Shuffled && deck.ShuffleAlgorithm == "" {
+		deck.ShuffleAlgorithm = data.DefaultShuffleAlgorithm
+	}
+
+	v := validator.New()
+
+	if deck.ShuffleAlgorithm != "" {
+		v.Check(deck.Shuffled, "shuffle_algorithm", "must only be provided when shuffled is true")
+		data.ValidateShuffleAlgorithm(v, deck.ShuffleAlgorithm)
+	}
+
+	if input.TTL != nil {
+		data.ValidateTTL(v, *input.TTL)
+
+		expiresAt := time.Now().Add(time.Duration(*input.TTL) * time.Second)
+		deck.ExpiresAt = &expiresAt
+	}
+
+	data.ValidateLabels(v, deck.Labels)
 
+	if data.ValidateDeckCount(v, deck.DeckCount); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if deck.Cards != nil {
 		if data.ValidateCardsInput(v, deck); !v.Valid() {
 			app.failedValidationResponse(w, r, v.Errors)
 			return
 		}
 	}
 
-	app.prepForInsert(deck)
+	err = app.prepForInsert(deck)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	err = app.models.Decks.Insert(deck)
+	err = app.models.Decks.Insert(r.Context(), deck, app.newEvent(r, data.EventCreate, nil))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	totalDecksCreated.Add(1)
+
 	app.prepForCreateResponse(deck)
 
 	headers := make(http.Header)
@@ -55,46 +111,123 @@ func (app *application) createDeckHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (app *application) showDeckHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (app *application) showDeckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deck := app.contextGetDeck(r)
+
+	app.prepForShowResponse(deck)
+
+	err := app.writeJSON(w, http.StatusOK, deck, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listDecksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.DeckFilters{
+		Shuffled:      app.readBool(qs, "shuffled", v),
+		MinRemaining:  app.readInt(qs, "remaining_min", 0, v),
+		MaxRemaining:  app.readInt(qs, "remaining_max", data.MaxCards, v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+		CreatedBefore: app.readTime(qs, "created_before", v),
+		Labels:        app.readLabels(qs, "label", v),
+		Filters: data.Filters{
+			Page:         app.readInt(qs, "page", 1, v),
+			PageSize:     app.readInt(qs, "page_size", 20, v),
+			Sort:         app.readString(qs, "sort", "-created_at"),
+			SortSafelist: data.DeckSortSafelist,
+		},
+	}
+
+	// Authenticated users list their own decks, anonymous clients the decks
+	// without an owner.
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		filters.OwnerID = &user.ID
+	}
+
+	if data.ValidateDeckFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	decks, metadata, err := app.models.Decks.GetAll(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]any{"decks": decks, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) drawCardsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		Count int `json:"count"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateCount(v, input.Count); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	id, err := app.readIDParam(ps)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	deck, err := app.models.Decks.Get(id)
+	draw, err := app.models.Decks.Draw(r.Context(), id, input.Count, app.newEvent(r, data.EventDraw, nil))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDeckClosed):
+			app.deckClosedResponse(w, r)
+		case errors.Is(err, data.ErrDeckDealt):
+			app.failedValidationResponse(w, r, map[string]string{"deck": "has already been dealt"})
+		case errors.Is(err, data.ErrNotEnoughCards):
+			app.failedValidationResponse(w, r, map[string]string{"deck": "has less cards than requested"})
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	app.prepForShowResponse(deck)
+	totalCardsDealt.Add(int64(len(draw.Cards)))
 
-	err = app.writeJSON(w, http.StatusOK, deck, nil)
+	err = app.writeJSON(w, http.StatusOK, map[string][]data.Card{"cards": draw.Cards}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) drawCardsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (app *application) returnCardsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var input struct {
-		Count int `json:"count"`
+		Cards []data.Card `json:"cards"`
+		To    string      `json:"to"`
 	}
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.readJSONErrorResponse(w, r, err)
 		return
 	}
 
 	v := validator.New()
 
-	if app.validateCount(v, input.Count); !v.Valid() {
+	if data.ValidateReturnInput(v, input.Cards, input.To); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -105,37 +238,247 @@ func (app *application) drawCardsHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	deck, err := app.models.Decks.Get(id)
+	if len(input.Cards) == 0 {
+		input.Cards = nil
+	}
+
+	deck, err := app.models.Decks.Return(r.Context(), id, input.Cards, input.To, app.newEvent(r, data.EventReturn, nil))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDeckClosed):
+			app.deckClosedResponse(w, r)
+		case errors.Is(err, data.ErrDeckRevealed):
+			app.deckRevealedResponse(w, r)
+		case errors.Is(err, data.ErrCardsNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"cards": "must have been dealt from this deck"})
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	v = validator.New()
+	app.prepForCreateResponse(deck)
+
+	err = app.writeJSON(w, http.StatusOK, deck, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) shuffleDeckHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deck := app.contextGetDeck(r)
 
-	if app.validateForDraw(v, input.Count, deck); !v.Valid() {
+	for attempt := 1; ; attempt++ {
+		// The first attempt uses the deck requireDeckOwner fetched; after an
+		// edit conflict the deck has to be read again.
+		if attempt > 1 {
+			deck, err = app.models.Decks.Get(r.Context(), id)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		if deck.Closed {
+			app.deckClosedResponse(w, r)
+			return
+		}
+
+		// A reshuffle would leave the commitment, and the seed the reveal
+		// publishes, for an order the deck is no longer in.
+		if deck.Commitment != "" {
+			app.deckCommittedResponse(w, r)
+			return
+		}
+
+		algorithm := deck.ShuffleAlgorithm
+		if algorithm == "" {
+			algorithm = data.DefaultShuffleAlgorithm
+		}
+
+		shuffler, err := data.NewCryptoShuffler(algorithm)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		event := app.newEvent(r, data.EventShuffle, nil)
+
+		err = data.ReshuffleDeck(deck, shuffler, event)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Decks.Update(r.Context(), deck, event)
+		if err == nil {
+			break
+		}
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && attempt < maxEditAttempts:
+			continue
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.prepForCreateResponse(deck)
+
+	err = app.writeJSON(w, http.StatusOK, deck, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) closeDeckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deck := app.contextGetDeck(r)
+
+	if !deck.Closed {
+		deck.Closed = true
+
+		err := app.models.Decks.Update(r.Context(), deck, app.newEvent(r, data.EventClose, nil))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.prepForCreateResponse(deck)
+
+	err := app.writeJSON(w, http.StatusOK, deck, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revealDeckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deck := app.contextGetDeck(r)
+
+	v := validator.New()
+
+	v.Check(deck.Commitment != "", "deck", "was not shuffled with a commitment")
+	if v.Check(deck.Revealable(), "deck", "must be closed, or fully dealt with empty piles, before it is revealed"); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	cardsForReturn := deck.StringCards[:input.Count]
-	cardsForUpdate := deck.StringCards[input.Count:]
+	err := app.writeJSON(w, http.StatusOK, data.NewReveal(deck), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-	deck.Cards = data.GenerateCards(cardsForUpdate)
-	returnCards := data.GenerateCards(cardsForReturn)
+func (app *application) undoDrawsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		Draws   *int `json:"draws"`
+		Version *int `json:"version"`
+	}
 
-	err = app.models.Decks.Update(deck)
+	// An empty body is read as one without fields, so it is reported as a
+	// missing version rather than a bad request.
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	draws := 1
+	if input.Draws != nil {
+		draws = *input.Draws
+	}
+
+	v := validator.New()
+
+	data.ValidateUndoDraws(v, draws)
+
+	// The version is required, so an undo never reverses a draw made by
+	// someone else since the client last saw the deck.
+	v.Check(input.Version != nil, "version", "must be provided")
+	if input.Version != nil {
+		v.Check(*input.Version > 0, "version", "must be more than zero")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	version := *input.Version
+
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deck, err := app.models.Decks.Undo(r.Context(), id, draws, version, app.newEvent(r, data.EventUndo, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDeckClosed):
+			app.deckClosedResponse(w, r)
+		case errors.Is(err, data.ErrNothingToUndo):
+			app.failedValidationResponse(w, r, map[string]string{"deck": "has no draws to undo since its last change"})
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.prepForCreateResponse(deck)
+
+	err = app.writeJSON(w, http.StatusOK, deck, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteDeckHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Decks.Delete(r.Context(), id, app.newEvent(r, data.EventDelete, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string][]data.Card{"cards": returnCards}, nil)
+	err = app.writeJSON(w, http.StatusOK, map[string]string{"message": "deck successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
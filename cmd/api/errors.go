@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/scchi/cards/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
-		"request metehod": r.Method,
-		"request_url":     r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
+		"request_method": r.Method,
+		"request_url":    redactedURL(r),
 	})
 }
 
@@ -25,12 +30,34 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		// The client went away and its queries were cancelled with it, so
+		// there is nobody left to answer.
+		app.logger.PrintInfo("request cancelled by client", map[string]string{
+			"request_id":     app.contextGetRequestID(r),
+			"request_method": r.Method,
+			"request_url":    redactedURL(r),
+		})
+		return
+	case data.QueryCanceled(err):
+		app.queryTimeoutResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+func (app *application) queryTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the database took too long to respond, please try again"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
 	app.errorResponse(w, r, http.StatusNotFound, message)
@@ -45,6 +72,95 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) deckCommittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the deck is committed to the order it was created in and can't be reshuffled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) deckRevealedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the order of the deck may already have been revealed, so cards can't be returned to it"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) deckClosedResponse(w http.ResponseWriter, r *http.Request) {
+	app.failedValidationResponse(w, r, map[string]string{"deck": "is closed"})
+}
+
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+func (app *application) readJSONErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var cardErr *data.CardError
+
+	switch {
+	case errors.As(err, &cardErr):
+		app.failedValidationResponse(w, r, map[string]string{"cards": cardErr.Error()})
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+func (app *application) pileTransferErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrDeckClosed):
+		app.deckClosedResponse(w, r)
+	case errors.Is(err, data.ErrNotEnoughCards):
+		app.failedValidationResponse(w, r, map[string]string{"pile": "has less cards than requested"})
+	case errors.Is(err, data.ErrCardsNotFound):
+		app.failedValidationResponse(w, r, map[string]string{"cards": "must all be in the pile"})
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+
+	message := "invalid or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidShareTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked share token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/validator"
+)
+
+func (app *application) listDeckEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deck := app.contextGetDeck(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	cursor := data.Cursor{
+		After: int64(app.readInt(qs, "cursor", 0, v)),
+		Limit: app.readInt(qs, "limit", 20, v),
+	}
+
+	if data.ValidateCursor(v, cursor); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Events.GetAllForDeck(r.Context(), deck.ID, cursor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]any{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/scchi/cards/internal/assert"
 	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/jsonlog"
 )
 
 var errorResponse struct {
@@ -49,6 +54,38 @@ func TestCreateDeck(t *testing.T) {
 		}
 	})
 
+	t.Run("Returns the reason an invalid card code was rejected", func(t *testing.T) {
+		tests := []struct {
+			name string
+			code string
+			want string
+		}{
+			{"Unknown suit", "AZ", `invalid card "AZ": unknown suit "Z"`},
+			{"Unknown rank", "1S", `invalid card "1S": unknown rank "1"`},
+			{"Missing suit", "A", `invalid card "A": must contain a rank and a suit`},
+			{"Empty code", "", `invalid card "": must not be empty`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				js, err := json.Marshal(map[string][]string{"cards": {"8D", tt.code}})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				statusCode, _, body := ts.post(t, path, bytes.NewReader(js))
+
+				var got struct {
+					Error map[string]string `json:"error"`
+				}
+				json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+				assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+				assert.Equal(t, got.Error["cards"], tt.want)
+			})
+		}
+	})
+
 	t.Run("Returns http.StatusBadRequest for body with wrong value types", func(t *testing.T) {
 		testBodies := []map[string]interface{}{
 			{
@@ -95,6 +132,84 @@ func TestCreateDeck(t *testing.T) {
 				"shuffled": true,
 				"cards":    []string{"AC", "KH", "6D"},
 			},
+			{
+				"cards": []string{"10S", "T♥", "qd"},
+			},
+		}
+
+		for _, testBody := range testBodies {
+			js, err := json.Marshal(testBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statusCode, _, _ := ts.post(t, path, bytes.NewReader(js))
+			assert.Equal(t, statusCode, http.StatusCreated)
+		}
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid deck_count values", func(t *testing.T) {
+		testBodies := []map[string]interface{}{
+			{
+				"deck_count": 0,
+			},
+			{
+				"deck_count": -1,
+			},
+			{
+				"deck_count": data.MaxDeckCount + 1,
+			},
+			{
+				"deck_count": 2,
+				"cards":      []string{"AS", "AS", "AS"},
+			},
+		}
+
+		for _, testBody := range testBodies {
+			js, err := json.Marshal(testBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statusCode, _, _ := ts.post(t, path, bytes.NewReader(js))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid ttl values", func(t *testing.T) {
+		for _, ttl := range []int{0, -1, data.MaxTTL + 1} {
+			js, err := json.Marshal(map[string]int{"ttl": ttl})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statusCode, _, _ := ts.post(t, path, bytes.NewReader(js))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Sets expires_at from ttl", func(t *testing.T) {
+		before := time.Now()
+
+		statusCode, _, body := ts.post(t, path, strings.NewReader(`{"ttl": 3600}`))
+
+		var got data.Deck
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusCreated)
+		assert.Equal(t, got.ExpiresAt != nil, true)
+		assert.Equal(t, got.ExpiresAt.Sub(before) >= time.Hour-time.Second, true)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid shuffle_algorithm values", func(t *testing.T) {
+		testBodies := []map[string]interface{}{
+			{
+				"shuffled":          true,
+				"shuffle_algorithm": "bogo",
+			},
+			{
+				"shuffle_algorithm": data.AlgorithmRiffle,
+			},
 		}
 
 		for _, testBody := range testBodies {
@@ -104,7 +219,59 @@ func TestCreateDeck(t *testing.T) {
 			}
 
 			statusCode, _, _ := ts.post(t, path, bytes.NewReader(js))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Shuffles with the requested algorithm", func(t *testing.T) {
+		for _, algorithm := range data.ShuffleAlgorithms {
+			js, err := json.Marshal(map[string]interface{}{"shuffled": true, "shuffle_algorithm": algorithm})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statusCode, _, body := ts.post(t, path, bytes.NewReader(js))
+
+			var got data.Deck
+			json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+			assert.Equal(t, statusCode, http.StatusCreated)
+			assert.Equal(t, got.ShuffleAlgorithm, algorithm)
+			assert.Equal(t, got.Remaining, data.CardsPerDeck)
+		}
+	})
+
+	t.Run("Builds a shoe from deck_count decks", func(t *testing.T) {
+		testBodies := []struct {
+			body          map[string]interface{}
+			wantRemaining int
+		}{
+			{
+				body:          map[string]interface{}{"deck_count": 6},
+				wantRemaining: 6 * data.CardsPerDeck,
+			},
+			{
+				body:          map[string]interface{}{"deck_count": 8, "shuffled": true},
+				wantRemaining: 8 * data.CardsPerDeck,
+			},
+			{
+				body:          map[string]interface{}{"deck_count": 2, "cards": []string{"AS", "AS", "KD"}},
+				wantRemaining: 3,
+			},
+		}
+
+		for _, tt := range testBodies {
+			js, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statusCode, _, body := ts.post(t, path, bytes.NewReader(js))
+			json.NewDecoder(bytes.NewReader(body)).Decode(&deck)
+
 			assert.Equal(t, statusCode, http.StatusCreated)
+			assert.Equal(t, deck.Remaining, tt.wantRemaining)
+			assert.Equal(t, deck.DeckCount, tt.body["deck_count"].(int))
 		}
 	})
 
@@ -231,6 +398,7 @@ func TestGetDeck(t *testing.T) {
 		assert.Equal(t, deck.ID, data.MockID)
 		assert.Equal(t, deck.Shuffled, data.MockShuffled)
 		assert.Equal(t, deck.Remaining, len(data.MockCards))
+		assert.Equal(t, deck.DeckCount, 1)
 	})
 
 	t.Run("Returns cards array for valid id with each card having suit, value and code fields", func(t *testing.T) {
@@ -251,10 +419,59 @@ func TestGetDeck(t *testing.T) {
 	})
 }
 
+// countingDeckModel counts the reads of a deck.
+type countingDeckModel struct {
+	data.MockDeckModel
+	gets *int
+}
+
+func (m countingDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	*m.gets++
+	return m.MockDeckModel.Get(ctx, id)
+}
+
+func TestDeckReads(t *testing.T) {
+	app := newTestApplication(t)
+
+	gets := 0
+	app.models.Decks = countingDeckModel{gets: &gets}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		urlPath string
+	}{
+		{name: "Show", method: http.MethodGet, urlPath: "/v1/decks/%s"},
+		{name: "Close", method: http.MethodPost, urlPath: "/v1/decks/%s/close"},
+		{name: "Reveal", method: http.MethodGet, urlPath: "/v1/decks/%s/reveal"},
+		{name: "Events", method: http.MethodGet, urlPath: "/v1/decks/%s/events"},
+		{name: "Shuffle", method: http.MethodPost, urlPath: "/v1/decks/%s/shuffle"},
+		{name: "Share", method: http.MethodPost, urlPath: "/v1/decks/%s/shares"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" reads the deck once", func(t *testing.T) {
+			gets = 0
+
+			urlPath := fmt.Sprintf(tt.urlPath, data.MockID)
+			if tt.method == http.MethodGet {
+				ts.get(t, urlPath)
+			} else {
+				ts.post(t, urlPath, strings.NewReader("{}"))
+			}
+
+			assert.Equal(t, gets, 1)
+		})
+	}
+}
+
 func TestDrawDeck(t *testing.T) {
 	app := newTestApplication(t)
 
-	t.Run("Should return an error if count is less than 1 or greater than 52", func(t *testing.T) {
+	t.Run("Should return an error if count is less than 1 or greater than the largest shoe", func(t *testing.T) {
 		counts := []map[string]int{
 			{
 				"count": 0,
@@ -265,6 +482,9 @@ func TestDrawDeck(t *testing.T) {
 			{
 				"count": 53,
 			},
+			{
+				"count": data.MaxCards + 1,
+			},
 		}
 
 		for _, count := range counts {
@@ -370,3 +590,1332 @@ func TestDrawDeck(t *testing.T) {
 	})
 
 }
+
+func TestPiles(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	pilePath := fmt.Sprintf("/v1/decks/%s/piles/%s", data.MockID, data.MockPileName)
+
+	t.Run("Dealing to a pile returns the pile with the dealt cards", func(t *testing.T) {
+		statusCode, _, body := ts.post(t, pilePath, strings.NewReader(`{"count": 2}`))
+
+		var pile data.Pile
+		json.NewDecoder(bytes.NewReader(body)).Decode(&pile)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, pile.Name, data.MockPileName)
+		assert.Equal(t, pile.Remaining, 2)
+		assert.Equal(t, pile.Cards[0].Code(), "AS")
+	})
+
+	t.Run("Dealing to a pile returns an error for invalid pile names and counts", func(t *testing.T) {
+		tests := []struct {
+			path string
+			body string
+		}{
+			{fmt.Sprintf("/v1/decks/%s/piles/Hand", data.MockID), `{"count": 1}`},
+			{pilePath, `{"count": 0}`},
+			{pilePath, `{"count": 3}`},
+		}
+
+		for _, tt := range tests {
+			statusCode, _, _ := ts.post(t, tt.path, strings.NewReader(tt.body))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Returns http.StatusNotFound for unknown decks and piles", func(t *testing.T) {
+		statusCode, _, _ := ts.get(t, fmt.Sprintf("/v1/decks/%s/piles/discard", data.MockID))
+		assert.Equal(t, statusCode, http.StatusNotFound)
+
+		statusCode, _, _ = ts.get(t, "/v1/decks/wrongid/piles/hand")
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+
+	t.Run("Lists the cards in a pile", func(t *testing.T) {
+		statusCode, _, body := ts.get(t, pilePath)
+
+		var pile data.Pile
+		json.NewDecoder(bytes.NewReader(body)).Decode(&pile)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, pile.Remaining, len(data.MockCards))
+	})
+
+	t.Run("Draws from the top, the bottom or specific cards of a pile", func(t *testing.T) {
+		tests := []struct {
+			body     string
+			wantCode string
+		}{
+			{`{"count": 1}`, "AS"},
+			{`{"count": 1, "from": "top"}`, "AS"},
+			{`{"count": 1, "from": "bottom"}`, "9D"},
+			{`{"cards": ["9d"]}`, "9D"},
+		}
+
+		for _, tt := range tests {
+			req, err := http.NewRequest(http.MethodPut, pilePath, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			var ca cardsArray
+			json.NewDecoder(rr.Body).Decode(&ca)
+
+			assert.Equal(t, rr.Code, http.StatusOK)
+			assert.Equal(t, len(ca.Cards), 1)
+			assert.Equal(t, ca.Cards[0].Code, tt.wantCode)
+		}
+	})
+
+	t.Run("Drawing cards that aren't in the pile returns an error", func(t *testing.T) {
+		bodies := []string{
+			`{"cards": ["KH"]}`,
+			`{"count": 1, "cards": ["AS"]}`,
+			`{"count": 1, "from": "middle"}`,
+		}
+
+		for _, body := range bodies {
+			req, err := http.NewRequest(http.MethodPut, pilePath, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Moves cards between piles", func(t *testing.T) {
+		statusCode, _, body := ts.post(t, pilePath+"/move", strings.NewReader(`{"to": "discard", "cards": ["9D"]}`))
+
+		var pile data.Pile
+		json.NewDecoder(bytes.NewReader(body)).Decode(&pile)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, pile.Name, "discard")
+		assert.Equal(t, pile.Cards[0].Code(), "9D")
+
+		statusCode, _, _ = ts.post(t, pilePath+"/move", strings.NewReader(`{"to": "hand", "count": 1}`))
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+	})
+}
+
+type revealedDeckModel struct {
+	data.MockDeckModel
+}
+
+func (m revealedDeckModel) Return(ctx context.Context, id string, cards []data.Card, position string, event *data.Event) (*data.Deck, error) {
+	return nil, data.ErrDeckRevealed
+}
+
+func TestReturnCards(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	returnPath := fmt.Sprintf("/v1/decks/%s/return", data.MockID)
+
+	t.Run("Returns dealt cards to the deck", func(t *testing.T) {
+		bodies := []string{
+			`{}`,
+			`{"cards": []}`,
+			`{"cards": ["KH"]}`,
+			`{"cards": ["KH"], "to": "bottom"}`,
+			`{"to": "random"}`,
+		}
+
+		for _, body := range bodies {
+			statusCode, _, respBody := ts.post(t, returnPath, strings.NewReader(body))
+
+			var got data.Deck
+			json.NewDecoder(bytes.NewReader(respBody)).Decode(&got)
+
+			assert.Equal(t, statusCode, http.StatusOK)
+			assert.Equal(t, got.Remaining, len(data.MockCards)+len(data.MockDealtCards))
+		}
+	})
+
+	t.Run("Refuses cards that weren't dealt from the deck", func(t *testing.T) {
+		bodies := []string{
+			`{"cards": ["AS"]}`,
+			`{"cards": ["KH", "KH"]}`,
+			`{"cards": ["QC"]}`,
+			`{"to": "middle"}`,
+		}
+
+		for _, body := range bodies {
+			statusCode, _, _ := ts.post(t, returnPath, strings.NewReader(body))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Returns http.StatusNotFound for invalid id", func(t *testing.T) {
+		statusCode, _, _ := ts.post(t, "/v1/decks/wrongid/return", strings.NewReader(`{}`))
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+
+	t.Run("Returns http.StatusConflict for a committed deck that may have been revealed", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.models.Decks = revealedDeckModel{}
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		statusCode, _, body := ts.post(t, returnPath, strings.NewReader(`{}`))
+		json.NewDecoder(bytes.NewReader(body)).Decode(&errorResponse)
+
+		assert.Equal(t, statusCode, http.StatusConflict)
+		assert.Equal(t, errorResponse.Error, "the order of the deck may already have been revealed, so cards can't be returned to it")
+	})
+}
+
+func TestShuffleDeck(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Shuffles the remaining cards", func(t *testing.T) {
+		statusCode, _, body := ts.post(t, fmt.Sprintf("/v1/decks/%s/shuffle", data.MockID), nil)
+
+		var got data.Deck
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, got.Shuffled, true)
+		assert.Equal(t, got.Remaining, len(data.MockCards))
+	})
+
+	t.Run("Returns http.StatusNotFound for invalid id", func(t *testing.T) {
+		statusCode, _, _ := ts.post(t, "/v1/decks/wrongid/shuffle", nil)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+
+	t.Run("Returns http.StatusConflict for a committed deck", func(t *testing.T) {
+		app.models.Decks = committedDeckModel{}
+		defer func() { app.models.Decks = data.MockDeckModel{} }()
+
+		statusCode, _, _ := ts.post(t, fmt.Sprintf("/v1/decks/%s/shuffle", data.MockID), nil)
+		assert.Equal(t, statusCode, http.StatusConflict)
+	})
+}
+
+// committedDeckModel is a deck created shuffled, with a commitment.
+type committedDeckModel struct {
+	data.MockDeckModel
+}
+
+func (m committedDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	deck, err := m.MockDeckModel.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deck.Commitment = data.Commit(make([]byte, data.SecretSize), deck.Cards)
+
+	return deck, nil
+}
+
+type conflictingDeckModel struct {
+	data.MockDeckModel
+}
+
+func (m conflictingDeckModel) Update(ctx context.Context, deck *data.Deck, event *data.Event) error {
+	return data.ErrEditConflict
+}
+
+func TestShuffleDeckConflict(t *testing.T) {
+	app := newTestApplication(t)
+	app.models.Decks = conflictingDeckModel{}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Returns http.StatusConflict when every attempt loses a race", func(t *testing.T) {
+		statusCode, _, body := ts.post(t, fmt.Sprintf("/v1/decks/%s/shuffle", data.MockID), nil)
+
+		json.NewDecoder(bytes.NewReader(body)).Decode(&errorResponse)
+
+		assert.Equal(t, statusCode, http.StatusConflict)
+		assert.Equal(t, errorResponse.Error, "unable to update the record due to an edit conflict, please try again")
+	})
+}
+
+// slowDeckModel is a database that doesn't answer before the context of the
+// query is done, or fails with err straight away.
+type slowDeckModel struct {
+	data.MockDeckModel
+	err error
+}
+
+func (m slowDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	app := newTestApplication(t)
+	deckPath := fmt.Sprintf("/v1/decks/%s", data.MockID)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"Context deadline", context.DeadlineExceeded},
+		{"Statement cancelled by Postgres", &pq.Error{Code: "57014"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.models.Decks = slowDeckModel{err: tt.err}
+
+			req := httptest.NewRequest(http.MethodGet, deckPath, nil)
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			json.NewDecoder(rr.Body).Decode(&errorResponse)
+
+			assert.Equal(t, rr.Code, http.StatusGatewayTimeout)
+			assert.Equal(t, errorResponse.Error, "the database took too long to respond, please try again")
+		})
+	}
+
+	t.Run("Cancels the query when the client goes away", func(t *testing.T) {
+		app.models.Decks = slowDeckModel{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, deckPath, nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		app.routes().ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Body.Len(), 0)
+	})
+}
+
+type closedDeckModel struct {
+	data.MockDeckModel
+}
+
+func (m closedDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	deck, err := m.MockDeckModel.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deck.Closed = true
+	deck.Commitment = data.Commit([]byte{1, 2}, deck.InitialCards)
+	deck.Secret = []byte{1, 2}
+
+	return deck, nil
+}
+
+func (m closedDeckModel) Draw(ctx context.Context, id string, count int, event *data.Event) (*data.Draw, error) {
+	return nil, data.ErrDeckClosed
+}
+
+// exhaustedDeckModel serves a committed deck with no cards left, but some
+// still held in a pile.
+type exhaustedDeckModel struct {
+	data.MockDeckModel
+}
+
+func (m exhaustedDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	deck, err := closedDeckModel{}.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deck.Closed = false
+	deck.Cards = nil
+	deck.Piles = map[string]int{data.MockPileName: 1}
+
+	return deck, nil
+}
+
+func TestPrepForInsert(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("Shuffles custom cards so the seed replays from the cards alone", func(t *testing.T) {
+		cards, err := data.ParseCards([]string{"KH", "2C", "AS", "10D", "QS", "3H"})
+		assert.NilError(t, err)
+
+		deck := &data.Deck{DeckCount: 1, Shuffled: true, ShuffleAlgorithm: data.DefaultShuffleAlgorithm, Cards: cards}
+
+		err = app.prepForInsert(deck)
+		assert.NilError(t, err)
+
+		replayed := append([]data.Card{}, deck.Cards...)
+		data.SortCards(replayed)
+
+		shuffler, err := data.NewSeededShuffler(deck.ShuffleAlgorithm, deck.ShuffleSeed)
+		assert.NilError(t, err)
+
+		_, err = shuffler.Shuffle(replayed)
+		assert.NilError(t, err)
+
+		for i := range replayed {
+			assert.Equal(t, replayed[i], deck.Cards[i])
+		}
+	})
+}
+
+func TestRevealDeck(t *testing.T) {
+	t.Run("Shuffled decks are created with a commitment", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.post(t, "/v1/decks", strings.NewReader(`{"shuffled": true}`))
+
+		var got data.Deck
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, len(got.Commitment), 64)
+
+		_, _, body = ts.post(t, "/v1/decks", strings.NewReader(`{}`))
+
+		got = data.Deck{}
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, got.Commitment, "")
+	})
+
+	t.Run("Refuses to reveal a deck that is still in play", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		statusCode, _, _ := ts.get(t, fmt.Sprintf("/v1/decks/%s/reveal", data.MockID))
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+
+		statusCode, _, _ = ts.get(t, "/v1/decks/wrongid/reveal")
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+
+	t.Run("Refuses to reveal an exhausted deck while a pile holds cards", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.models.Decks = exhaustedDeckModel{}
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		statusCode, _, _ := ts.get(t, fmt.Sprintf("/v1/decks/%s/reveal", data.MockID))
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("Reveals a closed deck with a verifiable commitment", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.models.Decks = closedDeckModel{}
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		statusCode, _, body := ts.get(t, fmt.Sprintf("/v1/decks/%s/reveal", data.MockID))
+
+		var reveal data.Reveal
+		json.NewDecoder(bytes.NewReader(body)).Decode(&reveal)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, data.VerifyCommitment(reveal.Commitment, reveal.Secret, reveal.Cards), true)
+	})
+
+	t.Run("Closed decks can't be dealt from", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.models.Decks = closedDeckModel{}
+
+		rr, _ := put(t, app, map[string]int{"count": 1}, fmt.Sprintf("/v1/decks/%s", data.MockID))
+		assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestCloseDeck(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	statusCode, _, body := ts.post(t, fmt.Sprintf("/v1/decks/%s/close", data.MockID), nil)
+
+	var got data.Deck
+	json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+	assert.Equal(t, statusCode, http.StatusOK)
+	assert.Equal(t, got.Closed, true)
+
+	statusCode, _, _ = ts.post(t, "/v1/decks/wrongid/close", nil)
+	assert.Equal(t, statusCode, http.StatusNotFound)
+}
+
+func TestListDeckEvents(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	eventsPath := fmt.Sprintf("/v1/decks/%s/events", data.MockID)
+
+	var response struct {
+		Events   []data.Event        `json:"events"`
+		Metadata data.CursorMetadata `json:"metadata"`
+	}
+
+	t.Run("Returns the events of a deck", func(t *testing.T) {
+		statusCode, _, body := ts.get(t, eventsPath+"?limit=1")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&response)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, len(response.Events), 1)
+		assert.Equal(t, response.Events[0].Action, data.EventCreate)
+		assert.Equal(t, response.Metadata.NextCursor, int64(1))
+	})
+
+	t.Run("Returns an empty page after the last event", func(t *testing.T) {
+		response.Events = nil
+
+		statusCode, _, body := ts.get(t, eventsPath+"?cursor=1")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&response)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, len(response.Events), 0)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid cursors and limits", func(t *testing.T) {
+		queries := []string{"?cursor=abc", "?cursor=-1", "?limit=0", "?limit=101", "?limit=x"}
+
+		for _, query := range queries {
+			statusCode, _, _ := ts.get(t, eventsPath+query)
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Returns http.StatusNotFound for invalid id", func(t *testing.T) {
+		statusCode, _, _ := ts.get(t, "/v1/decks/wrongid/events")
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+}
+
+func TestUndoDraws(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	undoPath := fmt.Sprintf("/v1/decks/%s/undo", data.MockID)
+
+	t.Run("Puts the last draw back on the deck", func(t *testing.T) {
+		for _, body := range []string{`{"version": %d}`, `{"draws": 1, "version": %d}`} {
+			statusCode, _, respBody := ts.post(t, undoPath, strings.NewReader(fmt.Sprintf(body, data.MockVersion)))
+
+			var got data.Deck
+			json.NewDecoder(bytes.NewReader(respBody)).Decode(&got)
+
+			assert.Equal(t, statusCode, http.StatusOK)
+			assert.Equal(t, got.Remaining, len(data.MockCards)+len(data.MockDealtCards))
+		}
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity when there is nothing to undo or draws is invalid", func(t *testing.T) {
+		bodies := []string{`{"draws": 2, "version": %d}`, `{"draws": 0, "version": %d}`, fmt.Sprintf(`{"draws": %d, "version": %%d}`, data.MaxUndoDraws+1)}
+
+		for _, body := range bodies {
+			statusCode, _, _ := ts.post(t, undoPath, strings.NewReader(fmt.Sprintf(body, data.MockVersion)))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity without a version", func(t *testing.T) {
+		for _, body := range []io.Reader{nil, strings.NewReader(`{"draws": 1}`)} {
+			statusCode, _, respBody := ts.post(t, undoPath, body)
+
+			var got struct {
+				Error map[string]string `json:"error"`
+			}
+			json.NewDecoder(bytes.NewReader(respBody)).Decode(&got)
+
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+			assert.Equal(t, got.Error["version"], "must be provided")
+		}
+	})
+
+	t.Run("Only undoes a deck still at the version the client saw", func(t *testing.T) {
+		statusCode, _, _ := ts.post(t, undoPath, strings.NewReader(fmt.Sprintf(`{"version": %d}`, data.MockVersion)))
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, _, body := ts.post(t, undoPath, strings.NewReader(fmt.Sprintf(`{"version": %d}`, data.MockVersion-1)))
+		json.NewDecoder(bytes.NewReader(body)).Decode(&errorResponse)
+
+		assert.Equal(t, statusCode, http.StatusConflict)
+		assert.Equal(t, errorResponse.Error, "unable to update the record due to an edit conflict, please try again")
+
+		statusCode, _, _ = ts.post(t, undoPath, strings.NewReader(`{"version": 0}`))
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("Returns http.StatusNotFound for invalid id", func(t *testing.T) {
+		statusCode, _, _ := ts.post(t, "/v1/decks/wrongid/undo", nil)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+}
+
+func TestDeleteDeck(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Deletes a deck", func(t *testing.T) {
+		statusCode, _, _ := ts.delete(t, fmt.Sprintf("/v1/decks/%s", data.MockID))
+		assert.Equal(t, statusCode, http.StatusOK)
+	})
+
+	t.Run("Returns http.StatusNotFound for invalid id", func(t *testing.T) {
+		statusCode, _, _ := ts.delete(t, "/v1/decks/wrongid")
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+}
+
+type purgingDeckModel struct {
+	data.MockDeckModel
+	batches *[]int64
+}
+
+func (m purgingDeckModel) Purge(ctx context.Context, idle time.Duration, limit int) (int64, error) {
+	count := (*m.batches)[0]
+	*m.batches = (*m.batches)[1:]
+
+	return count, nil
+}
+
+func TestPurgeDecks(t *testing.T) {
+	var logs bytes.Buffer
+
+	app := newTestApplication(t)
+	app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+	app.config.purge.batchSize = 2
+
+	t.Run("Purges batches until one comes back short and logs the total", func(t *testing.T) {
+		batches := []int64{2, 2, 1, 2}
+		app.models.Decks = purgingDeckModel{batches: &batches}
+
+		app.purgeDecks()
+
+		assert.Equal(t, len(batches), 1)
+		assert.Equal(t, strings.Contains(logs.String(), `"count":"5"`), true)
+	})
+
+	t.Run("Logs nothing when there is nothing to purge", func(t *testing.T) {
+		logs.Reset()
+
+		batches := []int64{0}
+		app.models.Decks = purgingDeckModel{batches: &batches}
+
+		app.purgeDecks()
+
+		assert.Equal(t, logs.Len(), 0)
+	})
+}
+
+func TestListDecks(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	var got struct {
+		Decks    []data.Deck   `json:"decks"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+
+	t.Run("Lists decks with metadata and without their cards", func(t *testing.T) {
+		statusCode, _, body := ts.get(t, "/v1/decks?shuffled=true&remaining_min=1&label=game:poker")
+
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, len(got.Decks), 1)
+		assert.Equal(t, got.Decks[0].ID, data.MockID)
+		assert.Equal(t, got.Decks[0].Remaining, len(data.MockCards))
+		assert.Equal(t, len(got.Decks[0].Cards), 0)
+		assert.Equal(t, got.Metadata.TotalRecords, 1)
+		assert.Equal(t, got.Metadata.LastPage, 1)
+	})
+
+	t.Run("Filters decks out", func(t *testing.T) {
+		statusCode, _, body := ts.get(t, "/v1/decks?shuffled=false")
+
+		got.Decks = nil
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, len(got.Decks), 0)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid query parameters", func(t *testing.T) {
+		queries := []string{
+			"shuffled=maybe",
+			"remaining_min=-1",
+			"remaining_min=10&remaining_max=5",
+			"remaining_max=abc",
+			"created_after=yesterday",
+			"created_after=2023-02-01T00:00:00Z&created_before=2023-01-01T00:00:00Z",
+			"label=nocolon",
+			"sort=secret",
+			"page=0",
+			"page_size=101",
+		}
+
+		for _, query := range queries {
+			statusCode, _, _ := ts.get(t, "/v1/decks?"+query)
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestBackground(t *testing.T) {
+	var logs bytes.Buffer
+
+	app := newTestApplication(t)
+	app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+	app.shutdown = make(chan struct{})
+
+	t.Run("Recovers and logs panics", func(t *testing.T) {
+		app.background(func() {
+			panic("boom")
+		})
+
+		app.wg.Wait()
+
+		assert.Equal(t, strings.Contains(logs.String(), "boom"), true)
+	})
+
+	t.Run("The purge worker returns on shutdown", func(t *testing.T) {
+		app.config.purge.interval = time.Millisecond
+		app.config.purge.batchSize = 10
+
+		app.startPurgeWorker()
+		time.Sleep(5 * time.Millisecond)
+		close(app.shutdown)
+
+		app.wg.Wait()
+	})
+}
+
+func TestRegisterUser(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Registers an inactive user", func(t *testing.T) {
+		statusCode, _, body := ts.post(t, "/v1/users", strings.NewReader(`{"name": "Bob", "email": "bob@example.com", "password": "pa55word1234"}`))
+
+		var got data.User
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusAccepted)
+		assert.Equal(t, got.Email, "bob@example.com")
+		assert.Equal(t, got.Activated, false)
+		assert.Equal(t, strings.Contains(string(body), "password"), false)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid or taken details", func(t *testing.T) {
+		bodies := []string{
+			`{"name": "", "email": "bob@example.com", "password": "pa55word1234"}`,
+			`{"name": "Bob", "email": "not-an-email", "password": "pa55word1234"}`,
+			`{"name": "Bob", "email": "bob@example.com", "password": "short"}`,
+			fmt.Sprintf(`{"name": "Alice", "email": %q, "password": "pa55word1234"}`, data.MockUserEmail),
+		}
+
+		for _, body := range bodies {
+			statusCode, _, _ := ts.post(t, "/v1/users", strings.NewReader(body))
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+type recordingMailer struct {
+	recipient    string
+	templateFile string
+	data         any
+}
+
+func (m *recordingMailer) Send(recipient, templateFile string, data any) error {
+	m.recipient = recipient
+	m.templateFile = templateFile
+	m.data = data
+
+	return nil
+}
+
+func TestRegisterUserEmail(t *testing.T) {
+	app := newTestApplication(t)
+
+	mailer := &recordingMailer{}
+	app.mailer = mailer
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Sends the activation token in a welcome email", func(t *testing.T) {
+		ts.post(t, "/v1/users", strings.NewReader(`{"name": "Bob", "email": "bob@example.com", "password": "pa55word1234"}`))
+
+		app.wg.Wait()
+
+		token := mailer.data.(map[string]any)["activationToken"].(string)
+
+		assert.Equal(t, mailer.recipient, "bob@example.com")
+		assert.Equal(t, mailer.templateFile, "user_welcome.tmpl")
+		assert.Equal(t, len(token), 26)
+	})
+}
+
+func TestActivateUser(t *testing.T) {
+	app := newTestApplication(t)
+
+	activate := func(body string) int {
+		req, err := http.NewRequest(http.MethodPut, "/v1/users/activated", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("Activates the user holding the token", func(t *testing.T) {
+		assert.Equal(t, activate(fmt.Sprintf(`{"token": %q}`, data.MockToken)), http.StatusOK)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid tokens", func(t *testing.T) {
+		assert.Equal(t, activate(`{"token": "short"}`), http.StatusUnprocessableEntity)
+		assert.Equal(t, activate(`{"token": "ZZZZZZZZZZZZZZZZZZZZZZZZZZ"}`), http.StatusUnprocessableEntity)
+	})
+}
+
+func TestCreateAuthenticationToken(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tokenPath := "/v1/tokens/authentication"
+
+	t.Run("Issues a token for valid credentials", func(t *testing.T) {
+		body := fmt.Sprintf(`{"email": %q, "password": %q}`, data.MockUserEmail, data.MockUserPassword)
+		statusCode, _, respBody := ts.post(t, tokenPath, strings.NewReader(body))
+
+		var got struct {
+			Token data.Token `json:"authentication_token"`
+		}
+		json.NewDecoder(bytes.NewReader(respBody)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusCreated)
+		assert.Equal(t, len(got.Token.Plaintext), 26)
+	})
+
+	t.Run("Returns http.StatusUnauthorized for wrong credentials", func(t *testing.T) {
+		bodies := []string{
+			fmt.Sprintf(`{"email": %q, "password": "wrongpassword"}`, data.MockUserEmail),
+			`{"email": "nobody@example.com", "password": "pa55word1234"}`,
+		}
+
+		for _, body := range bodies {
+			statusCode, _, _ := ts.post(t, tokenPath, strings.NewReader(body))
+			assert.Equal(t, statusCode, http.StatusUnauthorized)
+		}
+	})
+}
+
+type ownedDeckModel struct {
+	data.MockDeckModel
+	ownerID int64
+}
+
+func (m ownedDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	deck, err := m.MockDeckModel.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deck.OwnerID = &m.ownerID
+
+	return deck, nil
+}
+
+func TestDeckOwnership(t *testing.T) {
+	app := newTestApplication(t)
+
+	deckPath := fmt.Sprintf("/v1/decks/%s", data.MockID)
+
+	request := func(method, token string) int {
+		req, err := http.NewRequest(method, deckPath, strings.NewReader(`{"count": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("Only the owner may draw from a deck", func(t *testing.T) {
+		app.models.Decks = ownedDeckModel{ownerID: data.MockUserID}
+		assert.Equal(t, request(http.MethodPut, data.MockToken), http.StatusOK)
+		assert.Equal(t, request(http.MethodPut, ""), http.StatusUnauthorized)
+
+		app.models.Decks = ownedDeckModel{ownerID: data.MockUserID + 1}
+		assert.Equal(t, request(http.MethodPut, data.MockToken), http.StatusForbidden)
+		assert.Equal(t, request(http.MethodDelete, data.MockToken), http.StatusForbidden)
+	})
+
+	t.Run("Only the owner may look at an owned deck", func(t *testing.T) {
+		assert.Equal(t, request(http.MethodGet, data.MockToken), http.StatusForbidden)
+
+		app.models.Decks = ownedDeckModel{ownerID: data.MockUserID}
+		assert.Equal(t, request(http.MethodGet, data.MockToken), http.StatusOK)
+		assert.Equal(t, request(http.MethodGet, ""), http.StatusUnauthorized)
+
+		for _, suffix := range []string{"/reveal", "/events", "/piles/hand"} {
+			req, err := http.NewRequest(http.MethodGet, deckPath+suffix, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			assert.Equal(t, rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Anyone may use a deck without an owner", func(t *testing.T) {
+		app.models.Decks = data.MockDeckModel{}
+		defer func() { app.models.Decks = ownedDeckModel{ownerID: data.MockUserID} }()
+
+		for _, token := range []string{data.MockToken, ""} {
+			assert.Equal(t, request(http.MethodGet, token), http.StatusOK)
+			assert.Equal(t, request(http.MethodPut, token), http.StatusOK)
+			assert.Equal(t, request(http.MethodDelete, token), http.StatusOK)
+		}
+	})
+
+	t.Run("Returns http.StatusUnauthorized for invalid tokens", func(t *testing.T) {
+		assert.Equal(t, request(http.MethodGet, "ZZZZZZZZZZZZZZZZZZZZZZZZZZ"), http.StatusUnauthorized)
+		assert.Equal(t, request(http.MethodGet, "short"), http.StatusUnauthorized)
+	})
+}
+
+func TestAPIKeyPermissions(t *testing.T) {
+	app := newTestApplication(t)
+	app.models.Decks = ownedDeckModel{ownerID: data.MockUserID}
+
+	deckPath := fmt.Sprintf("/v1/decks/%s", data.MockID)
+
+	request := func(method, authorization string) int {
+		req, err := http.NewRequest(method, deckPath, strings.NewReader(`{"count": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", authorization)
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("A read-only key may show a deck but not draw from it", func(t *testing.T) {
+		assert.Equal(t, request(http.MethodGet, "ApiKey "+data.MockAPIKey), http.StatusOK)
+		assert.Equal(t, request(http.MethodPut, "ApiKey "+data.MockAPIKey), http.StatusForbidden)
+		assert.Equal(t, request(http.MethodDelete, "ApiKey "+data.MockAPIKey), http.StatusForbidden)
+	})
+
+	t.Run("The key's owner keeps all permissions", func(t *testing.T) {
+		assert.Equal(t, request(http.MethodPut, "Bearer "+data.MockToken), http.StatusOK)
+	})
+
+	t.Run("Returns http.StatusUnauthorized for unknown or malformed keys", func(t *testing.T) {
+		assert.Equal(t, request(http.MethodGet, "ApiKey ck_ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ"), http.StatusUnauthorized)
+		assert.Equal(t, request(http.MethodGet, "ApiKey "+data.MockToken), http.StatusUnauthorized)
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApplication(t)
+
+	request := func(method, urlPath, authorization, body string) (int, []byte) {
+		req, err := http.NewRequest(method, urlPath, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code, rr.Body.Bytes()
+	}
+
+	t.Run("Creates a key holding the requested permissions", func(t *testing.T) {
+		statusCode, body := request(http.MethodPost, "/v1/api-keys", "Bearer "+data.MockToken, `{"name": "overlay", "permissions": ["decks:read"]}`)
+
+		var got struct {
+			APIKey data.APIKey `json:"api_key"`
+		}
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusCreated)
+		assert.Equal(t, strings.HasPrefix(got.APIKey.Plaintext, data.APIKeyPrefix), true)
+		assert.Equal(t, got.APIKey.Permissions.Include(data.PermissionDecksRead), true)
+	})
+
+	t.Run("Returns http.StatusUnprocessableEntity for invalid keys", func(t *testing.T) {
+		bodies := []string{
+			`{"name": "", "permissions": ["decks:read"]}`,
+			`{"name": "overlay", "permissions": []}`,
+			`{"name": "overlay", "permissions": ["decks:steal"]}`,
+			`{"name": "overlay", "permissions": ["decks:read", "decks:read"]}`,
+		}
+
+		for _, body := range bodies {
+			statusCode, _ := request(http.MethodPost, "/v1/api-keys", "Bearer "+data.MockToken, body)
+			assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Lists the user's keys without their plaintext", func(t *testing.T) {
+		statusCode, body := request(http.MethodGet, "/v1/api-keys", "Bearer "+data.MockToken, "")
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, strings.Contains(string(body), data.MockAPIKey), false)
+		assert.Equal(t, strings.Contains(string(body), `"overlay"`), true)
+	})
+
+	t.Run("Revokes a key", func(t *testing.T) {
+		statusCode, _ := request(http.MethodDelete, "/v1/api-keys/1", "Bearer "+data.MockToken, "")
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, _ = request(http.MethodDelete, "/v1/api-keys/2", "Bearer "+data.MockToken, "")
+		assert.Equal(t, statusCode, http.StatusNotFound)
+	})
+
+	t.Run("Keys can't manage keys", func(t *testing.T) {
+		statusCode, _ := request(http.MethodPost, "/v1/api-keys", "ApiKey "+data.MockAPIKey, `{"name": "escalate", "permissions": ["decks:admin"]}`)
+		assert.Equal(t, statusCode, http.StatusForbidden)
+
+		statusCode, _ = request(http.MethodDelete, "/v1/api-keys/1", "ApiKey "+data.MockAPIKey, "")
+		assert.Equal(t, statusCode, http.StatusForbidden)
+	})
+
+	t.Run("Returns http.StatusUnauthorized for anonymous clients", func(t *testing.T) {
+		statusCode, _ := request(http.MethodGet, "/v1/api-keys", "", "")
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+	})
+}
+
+// revokedSharesDeckModel is a deck whose shares have been revoked once.
+type revokedSharesDeckModel struct {
+	ownedDeckModel
+}
+
+func (m revokedSharesDeckModel) Get(ctx context.Context, id string) (*data.Deck, error) {
+	deck, err := m.ownedDeckModel.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deck.ShareVersion++
+
+	return deck, nil
+}
+
+func TestShareTokens(t *testing.T) {
+	app := newTestApplication(t)
+	app.models.Decks = ownedDeckModel{ownerID: data.MockUserID}
+
+	deckPath := fmt.Sprintf("/v1/decks/%s", data.MockID)
+	sharesPath := deckPath + "/shares"
+
+	request := func(method, urlPath, token, body string) (int, []byte) {
+		req, err := http.NewRequest(method, urlPath, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code, rr.Body.Bytes()
+	}
+
+	share := func(role string) string {
+		statusCode, body := request(http.MethodPost, sharesPath, data.MockToken, fmt.Sprintf(`{"role": %q, "ttl": 3600}`, role))
+		assert.Equal(t, statusCode, http.StatusCreated)
+
+		var got struct {
+			Share data.ShareToken `json:"share_token"`
+		}
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		return got.Share.Plaintext
+	}
+
+	spectator := share(data.ShareRoleSpectator)
+	dealer := share(data.ShareRoleDealer)
+
+	t.Run("A spectator may watch the deck but not draw from it", func(t *testing.T) {
+		statusCode, _ := request(http.MethodGet, deckPath+"?share="+spectator, "", "")
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, _ = request(http.MethodPut, deckPath+"?share="+spectator, "", `{"count": 1}`)
+		assert.Equal(t, statusCode, http.StatusForbidden)
+	})
+
+	t.Run("Only a share token lets a non-owner look at the deck", func(t *testing.T) {
+		app.models.Decks = ownedDeckModel{ownerID: data.MockUserID + 1}
+		defer func() { app.models.Decks = ownedDeckModel{ownerID: data.MockUserID} }()
+
+		for _, suffix := range []string{"", "/events", "/piles/hand"} {
+			statusCode, _ := request(http.MethodGet, deckPath+suffix, "", "")
+			assert.Equal(t, statusCode, http.StatusUnauthorized)
+
+			statusCode, _ = request(http.MethodGet, deckPath+suffix, data.MockToken, "")
+			assert.Equal(t, statusCode, http.StatusForbidden)
+
+			statusCode, _ = request(http.MethodGet, deckPath+suffix+"?share="+spectator, "", "")
+			assert.Equal(t, statusCode, http.StatusOK)
+		}
+	})
+
+	t.Run("A dealer may draw from someone else's deck", func(t *testing.T) {
+		statusCode, _ := request(http.MethodPut, deckPath, "", `{"count": 1}`)
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+
+		statusCode, _ = request(http.MethodPut, deckPath+"?share="+dealer, "", `{"count": 1}`)
+		assert.Equal(t, statusCode, http.StatusOK)
+	})
+
+	t.Run("Shares only grant their own deck", func(t *testing.T) {
+		otherPath := "/v1/decks/b23d446a-f01a-4d6e-bec3-f928a3457ac7?share=" + dealer
+
+		statusCode, _ := request(http.MethodGet, otherPath, "", "")
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("Returns http.StatusUnauthorized for forged shares", func(t *testing.T) {
+		statusCode, _ := request(http.MethodGet, deckPath+"?share="+dealer+"x", "", "")
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("Only the owner may create or revoke shares", func(t *testing.T) {
+		statusCode, _ := request(http.MethodPost, sharesPath, "", `{"role": "dealer"}`)
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+
+		statusCode, _ = request(http.MethodPost, sharesPath, data.MockToken, `{"role": "owner"}`)
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+
+		statusCode, _ = request(http.MethodDelete, sharesPath, data.MockToken, "")
+		assert.Equal(t, statusCode, http.StatusOK)
+	})
+
+	t.Run("Revoking a deck's shares invalidates them all", func(t *testing.T) {
+		app.models.Decks = revokedSharesDeckModel{ownedDeckModel{ownerID: data.MockUserID}}
+
+		for _, share := range []string{spectator, dealer} {
+			statusCode, _ := request(http.MethodGet, deckPath+"?share="+share, "", "")
+			assert.Equal(t, statusCode, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/v1/healthcheck", "/v1/healthcheck/live"} {
+		statusCode, _, body := ts.get(t, path)
+
+		var got map[string]string
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, got["status"], "available")
+		assert.Equal(t, got["version"], version)
+		assert.Equal(t, got["commit"], commit)
+	}
+}
+
+// unhealthyModel is a database that is down, or migrated to the wrong
+// version.
+type unhealthyModel struct {
+	data.MockHealthModel
+	pingErr error
+	version int
+	dirty   bool
+}
+
+func (m unhealthyModel) Ping(ctx context.Context) error {
+	return m.pingErr
+}
+
+func (m unhealthyModel) MigrationVersion(ctx context.Context) (int, bool, error) {
+	return m.version, m.dirty, m.pingErr
+}
+
+func TestReadiness(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	var got struct {
+		Status string                    `json:"status"`
+		Checks map[string]map[string]any `json:"checks"`
+	}
+
+	t.Run("Is ready when the database is up and migrated", func(t *testing.T) {
+		statusCode, _, body := ts.get(t, "/v1/healthcheck/ready")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, statusCode, http.StatusOK)
+		assert.Equal(t, got.Status, "ready")
+		assert.Equal(t, got.Checks["database"]["status"] == "up", true)
+		assert.Equal(t, got.Checks["migrations"]["version"] == float64(data.SchemaVersion), true)
+	})
+
+	t.Run("Is ready at the oldest schema the code runs against", func(t *testing.T) {
+		app.models.Health = unhealthyModel{version: data.MinSchemaVersion}
+
+		statusCode, _, _ := ts.get(t, "/v1/healthcheck/ready")
+		assert.Equal(t, statusCode, http.StatusOK)
+	})
+
+	tests := []struct {
+		name  string
+		model unhealthyModel
+		check string
+	}{
+		{"Database down", unhealthyModel{pingErr: context.DeadlineExceeded}, "database"},
+		{"Old schema", unhealthyModel{version: data.MinSchemaVersion - 1}, "migrations"},
+		{"Newer schema", unhealthyModel{version: data.SchemaVersion + 1}, "migrations"},
+		{"Dirty schema", unhealthyModel{version: data.SchemaVersion, dirty: true}, "migrations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.models.Health = tt.model
+
+			statusCode, _, body := ts.get(t, "/v1/healthcheck/ready")
+			json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+			assert.Equal(t, statusCode, http.StatusServiceUnavailable)
+			assert.Equal(t, got.Status, "unavailable")
+			assert.Equal(t, got.Checks[tt.check]["status"] != "up", true)
+		})
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	app := newTestApplication(t)
+	app.models = data.NewMemoryModels(data.NewMemoryStore())
+
+	request := func(method, urlPath, header, body string) (int, []byte) {
+		req, err := http.NewRequest(method, urlPath, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		return rr.Code, rr.Body.Bytes()
+	}
+
+	statusCode, body := request(http.MethodPost, "/v1/decks", "", `{"shuffled": true}`)
+	assert.Equal(t, statusCode, http.StatusCreated)
+
+	var deck data.Deck
+	json.NewDecoder(bytes.NewReader(body)).Decode(&deck)
+	deckPath := "/v1/decks/" + deck.ID
+
+	t.Run("Draws and deals from a deck kept in memory", func(t *testing.T) {
+		statusCode, _ := request(http.MethodPut, deckPath, "", `{"count": 2}`)
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, body := request(http.MethodPost, deckPath+"/piles/discard", "", `{"count": 3}`)
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		var pile data.Pile
+		json.NewDecoder(bytes.NewReader(body)).Decode(&pile)
+		assert.Equal(t, pile.Remaining, 3)
+
+		statusCode, body = request(http.MethodGet, deckPath, "", "")
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		var got data.Deck
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+		assert.Equal(t, got.Remaining, 47)
+		assert.Equal(t, got.Piles["discard"], 3)
+	})
+
+	t.Run("Records the deck's events", func(t *testing.T) {
+		statusCode, body := request(http.MethodGet, deckPath+"/events", "", "")
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		var got struct {
+			Events []data.Event `json:"events"`
+		}
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+		assert.Equal(t, len(got.Events), 3)
+	})
+
+	t.Run("Has no accounts", func(t *testing.T) {
+		statusCode, _ := request(http.MethodPost, "/v1/users", "", `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+
+		statusCode, _ = request(http.MethodGet, deckPath, "Bearer "+data.MockToken, "")
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+
+		statusCode, _ = request(http.MethodGet, deckPath, "ApiKey "+data.MockAPIKey, "")
+		assert.Equal(t, statusCode, http.StatusUnauthorized)
+	})
+}
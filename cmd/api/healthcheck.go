@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
 )
 
+// healthcheckHandler is the liveness probe: it only says the process is up
+// and serving requests.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	data := map[string]string{
 		"status":      "available",
 		"environment": app.config.env,
 		"version":     version,
+		"commit":      commit,
+		"build_time":  buildTime,
 	}
 
 	err := app.writeJSON(w, http.StatusOK, data, nil)
@@ -18,3 +24,67 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessHandler is the readiness probe. The API is ready when the database
+// answers a ping within -readiness-timeout and has been migrated to a schema
+// version the code runs against.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.readinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := map[string]any{}
+
+	err := app.models.Health.Ping(ctx)
+	if err != nil {
+		status = http.StatusServiceUnavailable
+		checks["database"] = map[string]string{"status": "down", "error": err.Error()}
+	} else {
+		checks["database"] = map[string]string{"status": "up"}
+	}
+
+	migrations := map[string]any{"expected_version": data.SchemaVersion, "min_version": data.MinSchemaVersion}
+
+	version, dirty, err := app.models.Health.MigrationVersion(ctx)
+	switch {
+	case err != nil:
+		status = http.StatusServiceUnavailable
+		migrations["status"] = "unknown"
+		migrations["error"] = err.Error()
+	case !data.SchemaSupported(version, dirty):
+		status = http.StatusServiceUnavailable
+		migrations["status"] = "mismatch"
+		migrations["version"] = version
+		migrations["dirty"] = dirty
+	default:
+		migrations["status"] = "up"
+		migrations["version"] = version
+		migrations["dirty"] = dirty
+	}
+
+	checks["migrations"] = migrations
+
+	stats := app.models.Health.Stats()
+
+	body := map[string]any{
+		"status": "ready",
+		"checks": checks,
+		"database_pool": map[string]any{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+		},
+	}
+
+	if status != http.StatusOK {
+		body["status"] = "unavailable"
+	}
+
+	err = app.writeJSON(w, status, body, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
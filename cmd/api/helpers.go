@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,6 +29,90 @@ func (app *application) readIDParam(ps httprouter.Params) (string, error) {
 	return id, nil
 }
 
+func (app *application) readPileParam(ps httprouter.Params) string {
+	return ps.ByName("pile")
+}
+
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readBool returns nil when key isn't set, so callers can tell "not given"
+// apart from false.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime reads an RFC 3339 timestamp, returning the zero time when key isn't
+// set.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readLabels reads every key:value pair given for key, e.g.
+// ?label=game:poker&label=table:7.
+func (app *application) readLabels(qs url.Values, key string, v *validator.Validator) data.Labels {
+	labels := data.Labels{}
+
+	for _, s := range qs[key] {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok {
+			v.AddError(key, "must be in key:value format")
+			continue
+		}
+
+		labels[name] = value
+	}
+
+	return labels
+}
+
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -98,14 +184,55 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
-func (app *application) prepForInsert(deck *data.Deck) {
+// redactedURL returns the URL of the request for the logs, with the value of
+// the share query parameter, a bearer credential, replaced.
+func redactedURL(r *http.Request) string {
+	u := *r.URL
+
+	qs := u.Query()
+	if qs.Has("share") {
+		qs.Set("share", "REDACTED")
+		u.RawQuery = qs.Encode()
+	}
+
+	return u.String()
+}
+
+// newEvent starts the event that records the change a request makes to a
+// deck. The deck model fills in the rest when it writes the change.
+func (app *application) newEvent(r *http.Request, action string, cards []data.Card) *data.Event {
+	return &data.Event{
+		Action:    action,
+		Cards:     cards,
+		RequestID: app.contextGetRequestID(r),
+	}
+}
+
+func (app *application) prepForInsert(deck *data.Deck) error {
 	if len(deck.Cards) == 0 || deck.Cards == nil {
-		deck.Cards = data.GenerateAllCards()
+		deck.Cards = data.GenerateShoe(deck.DeckCount)
 	}
 
-	if deck.Shuffled {
-		data.ShuffleDeck(deck)
+	if !deck.Shuffled {
+		return nil
 	}
+
+	// Custom cards are put in the order of a new deck before the shuffle.
+	// Their order as sent is not stored, so this is what lets the revealed
+	// seed replay the shuffle from the revealed cards alone.
+	data.SortCards(deck.Cards)
+
+	shuffler, err := data.NewCryptoShuffler(deck.ShuffleAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	err = data.ShuffleDeck(deck, shuffler)
+	if err != nil {
+		return err
+	}
+
+	return data.CommitDeck(deck)
 }
 
 func (app *application) prepForCreateResponse(deck *data.Deck) {
@@ -114,20 +241,34 @@ func (app *application) prepForCreateResponse(deck *data.Deck) {
 }
 
 func (app *application) prepForShowResponse(deck *data.Deck) {
-	deck.Cards = data.GenerateCards(deck.StringCards)
 	deck.Remaining = len(deck.Cards)
 }
 
+func (app *application) prepForPileResponse(pile *data.Pile) {
+	pile.Remaining = len(pile.Cards)
+}
+
 func (app *application) validateCount(v *validator.Validator, count int) {
 	v.Check(count > 0, "count", "must be more than zero")
-	v.Check(count <= 52, "count", "must be equal or less than 52")
+	v.Check(count <= data.MaxCards, "count", fmt.Sprintf("must be equal or less than %d", data.MaxCards))
 }
 
-func (app *application) validateForDraw(v *validator.Validator, count int, deck *data.Deck) {
-	cardsCount := len(deck.StringCards)
+// background runs fn in a goroutine that the server waits for before it
+// exits on shutdown. A panic in fn is logged instead of crashing the server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
 
-	v.Check(cardsCount > 0, "deck", "has already been dealt")
-	v.Check(count <= cardsCount, "deck", "has less cards than requested")
+		fn()
+	}()
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/scchi/cards/internal/assert"
 	"github.com/scchi/cards/internal/data"
@@ -19,7 +22,7 @@ func TestCreate(t *testing.T) {
 	app := newTestApplication(t)
 
 	testDB := newTestDB(t)
-	app.models = data.NewModels(testDB)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
 
 	ts := newTestServer(t, app.routes())
 	defer ts.Close()
@@ -270,7 +273,7 @@ func TestGet(t *testing.T) {
 	app := newTestApplication(t)
 
 	testDB := newTestDB(t)
-	app.models = data.NewModels(testDB)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
 
 	ts := newTestServer(t, app.routes())
 	defer ts.Close()
@@ -357,7 +360,7 @@ func TestDraw(t *testing.T) {
 	app := newTestApplication(t)
 
 	testDB := newTestDB(t)
-	app.models = data.NewModels(testDB)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
 
 	ts := newTestServer(t, app.routes())
 	defer ts.Close()
@@ -413,8 +416,8 @@ func TestDraw(t *testing.T) {
 			for idx, card := range ca.Cards {
 				wantCard := newDeck.Cards[idx]
 
-				assert.Equal(t, card.Suit, getSuit(wantCard))
-				assert.Equal(t, card.Value, getValue(wantCard))
+				assert.Equal(t, card.Suit, getSuit(t, wantCard))
+				assert.Equal(t, card.Value, getValue(t, wantCard))
 				assert.Equal(t, card.Code, wantCard)
 			}
 
@@ -489,3 +492,569 @@ func TestDraw(t *testing.T) {
 		}
 	})
 }
+
+func TestConcurrentDraws(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Parallel draws never deal the same card twice", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"deck_count": 1}`))
+		locationHeader := header["Location"][0]
+
+		const draws = 26
+
+		var wg sync.WaitGroup
+		results := make(chan cardsArray, draws)
+
+		for i := 0; i < draws; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				req, err := http.NewRequest(http.MethodPut, ts.URL+locationHeader, strings.NewReader(`{"count": 2}`))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				rs, err := ts.Client().Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer rs.Body.Close()
+
+				switch rs.StatusCode {
+				case http.StatusOK:
+					var dealt cardsArray
+					json.NewDecoder(rs.Body).Decode(&dealt)
+					results <- dealt
+				case http.StatusConflict:
+				default:
+					t.Errorf("unexpected status %d", rs.StatusCode)
+				}
+			}()
+		}
+
+		wg.Wait()
+		close(results)
+
+		seen := make(map[string]bool)
+		dealtCount := 0
+
+		for dealt := range results {
+			for _, card := range dealt.Cards {
+				if seen[card.Code] {
+					t.Errorf("card %s was dealt twice", card.Code)
+				}
+
+				seen[card.Code] = true
+				dealtCount++
+			}
+		}
+
+		var got data.Deck
+
+		_, _, body := ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, dealtCount+got.Remaining, data.CardsPerDeck)
+
+		for _, card := range got.Cards {
+			if seen[card.Code()] {
+				t.Errorf("card %s was dealt but is still in the deck", card.Code())
+			}
+		}
+	})
+}
+
+func TestPileTransfers(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Cards dealt to and moved between piles are never in two places", func(t *testing.T) {
+		newDeck := createBody{
+			Cards: []string{"AC", "KH", "QD", "3H", "5S"},
+		}
+
+		js, err := json.Marshal(newDeck)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, header, _ := ts.post(t, path, bytes.NewReader(js))
+		locationHeader := header["Location"][0]
+
+		statusCode, _, _ := ts.post(t, locationHeader+"/piles/hand", strings.NewReader(`{"count": 3}`))
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, _, _ = ts.post(t, locationHeader+"/piles/hand/move", strings.NewReader(`{"to": "discard", "cards": ["KH"]}`))
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		var hand, discard data.Pile
+		var got data.Deck
+
+		_, _, body := ts.get(t, locationHeader+"/piles/hand")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&hand)
+
+		_, _, body = ts.get(t, locationHeader+"/piles/discard")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&discard)
+
+		_, _, body = ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, got.Remaining, 2)
+		assert.Equal(t, got.Piles["hand"], 2)
+		assert.Equal(t, got.Piles["discard"], 1)
+		assert.Equal(t, hand.Cards[0].Code(), "AC")
+		assert.Equal(t, hand.Cards[1].Code(), "QD")
+		assert.Equal(t, discard.Cards[0].Code(), "KH")
+	})
+
+	t.Run("A failed transfer leaves the deck and piles untouched", func(t *testing.T) {
+		newDeck := createBody{
+			Cards: []string{"AC", "KH"},
+		}
+
+		js, err := json.Marshal(newDeck)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, header, _ := ts.post(t, path, bytes.NewReader(js))
+		locationHeader := header["Location"][0]
+
+		statusCode, _, _ := ts.post(t, locationHeader+"/piles/hand", strings.NewReader(`{"count": 3}`))
+		assert.Equal(t, statusCode, http.StatusUnprocessableEntity)
+
+		var got data.Deck
+
+		_, _, body := ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, got.Remaining, 2)
+		assert.Equal(t, len(got.Piles), 0)
+	})
+}
+
+func TestDeckEvents(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Records every change to a deck in order", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"cards": ["AC", "KH", "QD"]}`))
+		locationHeader := header["Location"][0]
+
+		req, err := http.NewRequest(http.MethodPut, ts.URL+locationHeader, strings.NewReader(`{"count": 2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", "draw-request")
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+
+		ts.post(t, locationHeader+"/return", strings.NewReader(`{"cards": ["KH"]}`))
+		ts.post(t, locationHeader+"/shuffle", nil)
+
+		var response struct {
+			Events   []data.Event        `json:"events"`
+			Metadata data.CursorMetadata `json:"metadata"`
+		}
+
+		_, _, body := ts.get(t, locationHeader+"/events?limit=2")
+		json.NewDecoder(bytes.NewReader(body)).Decode(&response)
+
+		assert.Equal(t, len(response.Events), 2)
+		assert.Equal(t, response.Events[0].Action, data.EventCreate)
+		assert.Equal(t, len(response.Events[0].Cards), 0)
+		assert.Equal(t, response.Events[1].Action, data.EventDraw)
+		assert.Equal(t, response.Events[1].RequestID, "draw-request")
+		assert.Equal(t, response.Events[1].Cards[1].Code(), "KH")
+
+		_, _, body = ts.get(t, fmt.Sprintf("%s/events?cursor=%d", locationHeader, response.Metadata.NextCursor))
+		json.NewDecoder(bytes.NewReader(body)).Decode(&response)
+
+		assert.Equal(t, len(response.Events), 2)
+		assert.Equal(t, response.Events[0].Action, data.EventReturn)
+		assert.Equal(t, response.Events[1].Action, data.EventShuffle)
+	})
+}
+
+func TestUndo(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	undo := func(t *testing.T, locationHeader string) int {
+		var deck struct {
+			Version int `json:"version"`
+		}
+
+		_, _, body := ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&deck)
+
+		statusCode, _, _ := ts.post(t, locationHeader+"/undo", strings.NewReader(fmt.Sprintf(`{"version": %d}`, deck.Version)))
+		return statusCode
+	}
+
+	t.Run("Undoing draws puts the cards back on top in their original order", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"cards": ["AC", "KH", "QD", "3H"]}`))
+		locationHeader := header["Location"][0]
+
+		put(t, app, map[string]int{"count": 1}, locationHeader)
+		put(t, app, map[string]int{"count": 2}, locationHeader)
+
+		assert.Equal(t, undo(t, locationHeader), http.StatusOK)
+		assert.Equal(t, undo(t, locationHeader), http.StatusOK)
+
+		var got cardsArray
+
+		_, _, body := ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		assert.Equal(t, len(got.Cards), 4)
+		assert.Equal(t, got.Cards[0].Code, "AC")
+		assert.Equal(t, got.Cards[1].Code, "KH")
+		assert.Equal(t, got.Cards[2].Code, "QD")
+
+		assert.Equal(t, undo(t, locationHeader), http.StatusUnprocessableEntity)
+	})
+
+	t.Run("A draw can't be undone once someone else drew after it", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"cards": ["AC", "KH", "QD"]}`))
+		locationHeader := header["Location"][0]
+
+		put(t, app, map[string]int{"count": 1}, locationHeader)
+
+		var mine struct {
+			Version int `json:"version"`
+		}
+
+		_, _, body := ts.get(t, locationHeader)
+		json.NewDecoder(bytes.NewReader(body)).Decode(&mine)
+
+		put(t, app, map[string]int{"count": 1}, locationHeader)
+
+		statusCode, _, _ := ts.post(t, locationHeader+"/undo", strings.NewReader(fmt.Sprintf(`{"version": %d}`, mine.Version)))
+		assert.Equal(t, statusCode, http.StatusConflict)
+	})
+
+	t.Run("A draw can't be undone after the deck was shuffled", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"cards": ["AC", "KH", "QD"]}`))
+		locationHeader := header["Location"][0]
+
+		put(t, app, map[string]int{"count": 1}, locationHeader)
+		ts.post(t, locationHeader+"/shuffle", nil)
+
+		assert.Equal(t, undo(t, locationHeader), http.StatusUnprocessableEntity)
+	})
+}
+
+func TestDeckLifecycle(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+	app.config.purge.batchSize = 10
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	var count int
+
+	t.Run("Deleting a deck removes it and its piles but keeps its events", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, nil)
+		locationHeader := header["Location"][0]
+		id := strings.TrimPrefix(locationHeader, "/v1/decks/")
+
+		ts.post(t, locationHeader+"/piles/hand", strings.NewReader(`{"count": 2}`))
+
+		statusCode, _, _ := ts.delete(t, locationHeader)
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		statusCode, _, _ = ts.get(t, locationHeader)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+
+		statusCode, _, _ = ts.delete(t, locationHeader)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+
+		rowExists(t, testDB, id, &count)
+		assert.Equal(t, count, 0)
+
+		err := testDB.QueryRow("SELECT COUNT(*) FROM piles WHERE deck_id::text = $1", id).Scan(&count)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+
+		var action string
+		err = testDB.QueryRow("SELECT action FROM deck_events WHERE deck_id::text = $1 ORDER BY id DESC LIMIT 1", id).Scan(&action)
+		assert.NilError(t, err)
+		assert.Equal(t, action, data.EventDelete)
+	})
+
+	t.Run("Expired decks are not found before they are purged", func(t *testing.T) {
+		_, header, _ := ts.post(t, path, strings.NewReader(`{"ttl": 60}`))
+		locationHeader := header["Location"][0]
+		id := strings.TrimPrefix(locationHeader, "/v1/decks/")
+
+		statusCode, _, _ := ts.get(t, locationHeader)
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		_, err := testDB.Exec("UPDATE decks SET expires_at = NOW() - interval '1 second' WHERE id::text = $1", id)
+		assert.NilError(t, err)
+
+		statusCode, _, _ = ts.get(t, locationHeader)
+		assert.Equal(t, statusCode, http.StatusNotFound)
+
+		rs, _ := put(t, app, map[string]int{"count": 1}, locationHeader)
+		assert.Equal(t, rs.Code, http.StatusNotFound)
+
+		rowExists(t, testDB, id, &count)
+		assert.Equal(t, count, 1)
+
+		app.purgeDecks()
+
+		rowExists(t, testDB, id, &count)
+		assert.Equal(t, count, 0)
+
+		var action string
+		err = testDB.QueryRow("SELECT COUNT(*), MAX(action) FROM deck_events WHERE deck_id::text = $1", id).Scan(&count, &action)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+		assert.Equal(t, action, data.EventPurge)
+	})
+
+	t.Run("Idle decks are purged", func(t *testing.T) {
+		app.config.purge.idleTimeout = time.Hour
+
+		_, header, _ := ts.post(t, path, nil)
+		id := strings.TrimPrefix(header["Location"][0], "/v1/decks/")
+
+		_, header, _ = ts.post(t, path, nil)
+		activeID := strings.TrimPrefix(header["Location"][0], "/v1/decks/")
+
+		_, err := testDB.Exec("UPDATE decks SET updated_at = NOW() - interval '2 hours' WHERE id::text = $1", id)
+		assert.NilError(t, err)
+
+		app.purgeDecks()
+
+		rowExists(t, testDB, id, &count)
+		assert.Equal(t, count, 0)
+
+		rowExists(t, testDB, activeID, &count)
+		assert.Equal(t, count, 1)
+	})
+}
+
+func TestDeckListing(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.post(t, path, strings.NewReader(`{"shuffled": true, "labels": {"game": "poker", "table": "1"}}`))
+	ts.post(t, path, strings.NewReader(`{"cards": ["AS", "KH"], "labels": {"game": "poker", "table": "2"}}`))
+	ts.post(t, path, strings.NewReader(`{"cards": ["2C"], "labels": {"game": "blackjack"}}`))
+
+	type listResponse struct {
+		Decks    []data.Deck   `json:"decks"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+
+	list := func(t *testing.T, query string) listResponse {
+		statusCode, _, body := ts.get(t, "/v1/decks?"+query)
+		assert.Equal(t, statusCode, http.StatusOK)
+
+		var got listResponse
+		json.NewDecoder(bytes.NewReader(body)).Decode(&got)
+
+		return got
+	}
+
+	t.Run("Filters by labels, shuffled and remaining cards", func(t *testing.T) {
+		assert.Equal(t, len(list(t, "label=game:poker").Decks), 2)
+		assert.Equal(t, len(list(t, "label=game:poker&label=table:2").Decks), 1)
+		assert.Equal(t, len(list(t, "shuffled=true").Decks), 1)
+		assert.Equal(t, len(list(t, "remaining_max=2").Decks), 2)
+		assert.Equal(t, len(list(t, "created_after=2000-01-01T00:00:00Z&created_before=2001-01-01T00:00:00Z").Decks), 0)
+	})
+
+	t.Run("Sorts and paginates", func(t *testing.T) {
+		got := list(t, "sort=remaining&page_size=2")
+
+		assert.Equal(t, len(got.Decks), 2)
+		assert.Equal(t, got.Decks[0].Remaining, 1)
+		assert.Equal(t, got.Decks[1].Remaining, 2)
+		assert.Equal(t, got.Decks[1].Labels["table"], "2")
+		assert.Equal(t, got.Metadata.TotalRecords, 3)
+		assert.Equal(t, got.Metadata.LastPage, 2)
+
+		got = list(t, "sort=remaining&page_size=2&page=2")
+
+		assert.Equal(t, len(got.Decks), 1)
+		assert.Equal(t, got.Decks[0].Remaining, 52)
+	})
+}
+
+func TestDeckOwners(t *testing.T) {
+	app := newTestApplication(t)
+
+	testDB := newTestDB(t)
+	app.models = data.NewModels(testDB, data.DefaultQueryTimeout)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	register := func(t *testing.T, email string) string {
+		body := fmt.Sprintf(`{"name": "Player", "email": %q, "password": "pa55word1234"}`, email)
+
+		statusCode, _, respBody := ts.post(t, "/v1/users", strings.NewReader(body))
+		assert.Equal(t, statusCode, http.StatusAccepted)
+
+		var user data.User
+		json.NewDecoder(bytes.NewReader(respBody)).Decode(&user)
+
+		activation, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeActivation)
+		assert.NilError(t, err)
+
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/v1/users/activated", strings.NewReader(fmt.Sprintf(`{"token": %q}`, activation.Plaintext)))
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+		assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+		body = fmt.Sprintf(`{"email": %q, "password": "pa55word1234"}`, email)
+
+		statusCode, _, respBody = ts.post(t, "/v1/tokens/authentication", strings.NewReader(body))
+		assert.Equal(t, statusCode, http.StatusCreated)
+
+		var got struct {
+			Token data.Token `json:"authentication_token"`
+		}
+		json.NewDecoder(bytes.NewReader(respBody)).Decode(&got)
+
+		return got.Token.Plaintext
+	}
+
+	do := func(t *testing.T, method, url, token, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		assert.NilError(t, err)
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs
+	}
+
+	alice := register(t, "alice@example.com")
+	bob := register(t, "bob@example.com")
+
+	t.Run("Only the owner of a deck may draw from or look at it", func(t *testing.T) {
+		rs := do(t, http.MethodPost, path, alice, "")
+		assert.Equal(t, rs.StatusCode, http.StatusCreated)
+
+		location := rs.Header.Get("Location")
+
+		assert.Equal(t, do(t, http.MethodPut, location, "", `{"count": 1}`).StatusCode, http.StatusUnauthorized)
+		assert.Equal(t, do(t, http.MethodPut, location, bob, `{"count": 1}`).StatusCode, http.StatusForbidden)
+		assert.Equal(t, do(t, http.MethodPut, location, alice, `{"count": 1}`).StatusCode, http.StatusOK)
+		assert.Equal(t, do(t, http.MethodGet, location, alice, "").StatusCode, http.StatusOK)
+		assert.Equal(t, do(t, http.MethodGet, location, bob, "").StatusCode, http.StatusForbidden)
+		assert.Equal(t, do(t, http.MethodGet, location+"/events", "", "").StatusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("Users list only their own decks", func(t *testing.T) {
+		do(t, http.MethodPost, path, "", "")
+
+		list := func(token string) int {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/decks", nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			defer rs.Body.Close()
+
+			var got struct {
+				Decks []data.Deck `json:"decks"`
+			}
+			json.NewDecoder(rs.Body).Decode(&got)
+
+			return len(got.Decks)
+		}
+
+		assert.Equal(t, list(alice), 1)
+		assert.Equal(t, list(bob), 0)
+		assert.Equal(t, list(""), 1)
+	})
+
+	t.Run("API keys act for their owner within their permissions", func(t *testing.T) {
+		rs := do(t, http.MethodPost, path, alice, "")
+		location := rs.Header.Get("Location")
+
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/api-keys", strings.NewReader(`{"name": "overlay", "permissions": ["decks:read"]}`))
+		req.Header.Set("Authorization", "Bearer "+alice)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		defer rs.Body.Close()
+		assert.Equal(t, rs.StatusCode, http.StatusCreated)
+
+		var got struct {
+			APIKey data.APIKey `json:"api_key"`
+		}
+		json.NewDecoder(rs.Body).Decode(&got)
+
+		withKey := func(method, url, body string) int {
+			req, _ := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+			req.Header.Set("Authorization", "ApiKey "+got.APIKey.Plaintext)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			return rs.StatusCode
+		}
+
+		assert.Equal(t, withKey(http.MethodGet, location, ""), http.StatusOK)
+		assert.Equal(t, withKey(http.MethodPut, location, `{"count": 1}`), http.StatusForbidden)
+
+		revoke := fmt.Sprintf("/v1/api-keys/%d", got.APIKey.ID)
+		assert.Equal(t, do(t, http.MethodDelete, revoke, bob, "").StatusCode, http.StatusNotFound)
+		assert.Equal(t, do(t, http.MethodDelete, revoke, alice, "").StatusCode, http.StatusOK)
+
+		assert.Equal(t, withKey(http.MethodGet, location, ""), http.StatusUnauthorized)
+	})
+}
@@ -1,28 +1,73 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"io"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/scchi/cards/internal/data"
 	"github.com/scchi/cards/internal/jsonlog"
+	"github.com/scchi/cards/internal/mailer"
 )
 
-const version = "1.0.0"
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...
+// -X main.buildTime=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port             int
+	env              string
+	shutdownTimeout  time.Duration
+	readinessTimeout time.Duration
+	storage          struct {
+		backend string
+		path    string
+	}
+	db struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		autoMigrate  bool
+	}
+	limiter struct {
+		rps            float64
+		burst          int
+		enabled        bool
+		trustedProxies []netip.Prefix
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	purge struct {
+		interval    time.Duration
+		idleTimeout time.Duration
+		batchSize   int
+	}
+	share struct {
+		secret string
+	}
+	metrics struct {
+		enabled   bool
+		allowlist []netip.Prefix
 	}
 }
 
@@ -30,47 +75,167 @@ type application struct {
 	config config
 	logger *jsonlog.Logger
 	models data.Models
+	mailer mailer.Mailer
+	// shutdown is closed once the server stops accepting requests, telling
+	// long-running background tasks to return.
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests to finish on shutdown")
+	flag.DurationVar(&cfg.readinessTimeout, "readiness-timeout", 2*time.Second, "How long the readiness probe waits for the database")
+	flag.StringVar(&cfg.storage.backend, "storage", "postgres", "Storage backend (postgres|memory|file)")
+	flag.StringVar(&cfg.storage.path, "storage-path", "cards.jsonl", "File the file storage backend keeps decks in")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "How long a deck query may run before it is cancelled")
+	flag.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending database migrations on start")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Func("limiter-trusted-proxies", "Trusted proxy IPs or CIDRs whose X-Forwarded-For is used to identify clients (space separated)", func(val string) error {
+		for _, s := range strings.Fields(val) {
+			prefix, err := parseTrustedProxy(s)
+			if err != nil {
+				return err
+			}
+
+			cfg.limiter.trustedProxies = append(cfg.limiter.trustedProxies, prefix)
+		}
+
+		return nil
+	})
+	flag.DurationVar(&cfg.purge.interval, "purge-interval", time.Minute, "How often expired and idle decks are purged (0 disables purging)")
+	flag.DurationVar(&cfg.purge.idleTimeout, "purge-idle-timeout", 24*time.Hour, "Also purge decks unchanged for this long (0 only purges expired decks)")
+	flag.IntVar(&cfg.purge.batchSize, "purge-batch-size", 500, "Maximum number of decks deleted per purge query")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host (leave empty to write emails to the log instead)")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Cards <no-reply@cards.local>", "SMTP sender")
+	flag.StringVar(&cfg.share.secret, "share-secret", os.Getenv("CARDS_SHARE_SECRET"), "Secret used to sign share tokens")
+	flag.BoolVar(&cfg.metrics.enabled, "metrics-enabled", true, "Serve metrics on /debug/vars and /metrics")
+	flag.Func("metrics-allowlist", "IPs or CIDRs allowed to read metrics (space separated, metrics are off while empty)", func(val string) error {
+		for _, s := range strings.Fields(val) {
+			prefix, err := parseTrustedProxy(s)
+			if err != nil {
+				return err
+			}
+
+			cfg.metrics.allowlist = append(cfg.metrics.allowlist, prefix)
+		}
+
+		return nil
+	})
+	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
 
+	if *displayVersion {
+		fmt.Printf("Version:\t%s\n", version)
+		fmt.Printf("Commit:\t\t%s\n", commit)
+		fmt.Printf("Build time:\t%s\n", buildTime)
+		os.Exit(0)
+	}
+
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
-	db, err := openDB(cfg)
+	if flag.Arg(0) == "migrate" {
+		err := migrateCommand(cfg, logger, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		os.Exit(0)
+	}
+
+	// Without a configured secret, share tokens only last until the server
+	// restarts.
+	if cfg.share.secret == "" {
+		secret := make([]byte, 32)
+
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		cfg.share.secret = hex.EncodeToString(secret)
+		logger.PrintInfo("no share secret configured, share tokens won't survive a restart", nil)
+	}
+
+	models, store, err := openStorage(cfg, logger)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
-	defer db.Close()
-	logger.PrintInfo("database connection pool established", nil)
+	defer store.Close()
+	logger.PrintInfo("storage opened", map[string]string{"storage": cfg.storage.backend})
+
+	expvar.NewString("version").Set(version)
+	expvar.NewString("commit").Set(commit)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:   cfg,
+		logger:   logger,
+		models:   models,
+		mailer:   newMailer(cfg, logger),
+		shutdown: make(chan struct{}),
+	}
+
+	app.startPurgeWorker()
+
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
+}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		ErrorLog:     log.New(logger, "", 0),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+// newMailer sends email through the configured SMTP server, or writes it to
+// the log when no server is configured, which is handy in development.
+func newMailer(cfg config, logger *jsonlog.Logger) mailer.Mailer {
+	if cfg.smtp.host == "" {
+		return mailer.NewLog(logger, cfg.smtp.sender)
 	}
 
-	logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  cfg.env,
-	})
+	return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+}
+
+// openStorage opens the models of the configured storage backend. Only
+// PostgreSQL keeps user accounts; the memory and file backends keep decks
+// alone. The returned closer releases the backend on shutdown. PostgreSQL is
+// only opened at a schema version the code runs against.
+func openStorage(cfg config, logger *jsonlog.Logger) (data.Models, io.Closer, error) {
+	switch cfg.storage.backend {
+	case "postgres":
+		db, err := openDB(cfg)
+		if err != nil {
+			return data.Models{}, nil, err
+		}
 
-	err = srv.ListenAndServe()
-	logger.PrintFatal(err, nil)
+		err = checkSchema(cfg, db, logger)
+		if err != nil {
+			db.Close()
+			return data.Models{}, nil, err
+		}
+
+		publishDBStats(db)
+
+		return data.NewModels(db, cfg.db.queryTimeout), db, nil
+	case "memory":
+		store := data.NewMemoryStore()
+		return data.NewMemoryModels(store), store, nil
+	case "file":
+		store, err := data.OpenFileStore(cfg.storage.path)
+		if err != nil {
+			return data.Models{}, nil, err
+		}
+
+		return data.NewMemoryModels(store), store, nil
+	default:
+		return data.Models{}, nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// These are published on /debug/vars, and on /metrics in the Prometheus text
+// format.
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_us")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+	totalDecksCreated               = expvar.NewInt("total_decks_created")
+	totalCardsDealt                 = expvar.NewInt("total_cards_dealt")
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// publishDBStats publishes the statistics of the connection pool as the
+// "database" variable. It may only be called once.
+func publishDBStats(db *sql.DB) {
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+}
+
+// prometheusHandler writes the published metrics in the Prometheus text
+// exposition format.
+func (app *application) prometheusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var buf bytes.Buffer
+
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	metric("cards_requests_total", "counter", "Requests received.", totalRequestsReceived.Value())
+	metric("cards_responses_total", "counter", "Responses sent.", totalResponsesSent.Value())
+	metric("cards_request_duration_seconds_total", "counter", "Time spent processing requests.", float64(totalProcessingTimeMicroseconds.Value())/1e6)
+
+	var statuses []string
+	totalResponsesSentByStatus.Do(func(kv expvar.KeyValue) {
+		statuses = append(statuses, kv.Key)
+	})
+	sort.Strings(statuses)
+
+	fmt.Fprintf(&buf, "# HELP cards_responses_by_status_total Responses sent by status code.\n# TYPE cards_responses_by_status_total counter\n")
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "cards_responses_by_status_total{status=%q} %s\n", status, totalResponsesSentByStatus.Get(status))
+	}
+
+	metric("cards_decks_created_total", "counter", "Decks created.", totalDecksCreated.Value())
+	metric("cards_cards_dealt_total", "counter", "Cards drawn from decks or dealt onto piles.", totalCardsDealt.Value())
+	metric("go_goroutines", "gauge", "Number of goroutines that currently exist.", runtime.NumGoroutine())
+
+	if v, ok := expvar.Get("database").(expvar.Func); ok {
+		if stats, ok := v.Value().(sql.DBStats); ok {
+			metric("cards_db_max_open_connections", "gauge", "Maximum number of open connections to the database.", stats.MaxOpenConnections)
+			metric("cards_db_open_connections", "gauge", "Open connections to the database.", stats.OpenConnections)
+			metric("cards_db_in_use_connections", "gauge", "Connections currently in use.", stats.InUse)
+			metric("cards_db_idle_connections", "gauge", "Idle connections.", stats.Idle)
+			metric("cards_db_wait_count_total", "counter", "Connections waited for.", stats.WaitCount)
+			metric("cards_db_wait_duration_seconds_total", "counter", "Time spent waiting for connections.", stats.WaitDuration.Seconds())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
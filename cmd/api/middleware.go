@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/validator"
+	"golang.org/x/time/rate"
+)
+
+// requestIDRX limits the request IDs taken from clients to characters that
+// are safe to log and echo back.
+var requestIDRX = regexp.MustCompile("^[A-Za-z0-9._:-]{1,128}$")
+
+// recoverPanic turns a panic into a 500 response and closes the connection.
+// It is the outermost middleware, so panics in the other middleware are
+// caught too.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				w.Header().Set("Connection", "close")
+				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit gives every client IP its own token bucket, refilled at
+// limiter.rps with room for limiter.burst requests. Clients that haven't been
+// seen for a few minutes are forgotten by a background task.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+
+	app.background(func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+
+				for ip, client := range clients {
+					if time.Since(client.lastSeen) > 3*time.Minute {
+						delete(clients, ip)
+					}
+				}
+
+				mu.Unlock()
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := app.clientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		mu.Lock()
+
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+			}
+		}
+
+		clients[ip].lastSeen = time.Now()
+
+		if !clients[ip].limiter.Allow() {
+			mu.Unlock()
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP of the client that sent the request. When the
+// request comes from a trusted proxy, the client is the right-most address in
+// X-Forwarded-For that isn't itself a trusted proxy; the entries left of it
+// can be forged by the client.
+func (app *application) clientIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	if !app.trustedProxy(host) {
+		return host, nil
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		host = addr.String()
+
+		if !app.trustedProxy(host) {
+			break
+		}
+	}
+
+	return host, nil
+}
+
+func (app *application) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range app.config.limiter.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTrustedProxy parses a trusted proxy given either as a CIDR or as a
+// single IP.
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// authenticate puts the user holding the bearer token or API key in the
+// Authorization header on the request context, or AnonymousUser when there is
+// no header. API keys are put on the context too, for requirePermission.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Storage backends without accounts can't check credentials.
+		if app.models.Users == nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
+
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// requireActivatedUser only lets authenticated users with an activated
+// account through.
+func (app *application) requireActivatedUser(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+// rejectAPIKeys keeps requests made with an API key out, so that a key can't
+// be used to mint or revoke keys.
+func (app *application) rejectAPIKeys(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+// authenticateShare verifies the share token in the share query parameter, if
+// any, and puts it on the request context. A token is only good for the deck
+// in the :id parameter and until the deck's shares are revoked.
+func (app *application) authenticateShare(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		plaintext := r.URL.Query().Get("share")
+		if plaintext == "" {
+			next(w, r, ps)
+			return
+		}
+
+		share, err := data.ParseShareToken(plaintext, []byte(app.config.share.secret))
+		if err != nil {
+			app.invalidShareTokenResponse(w, r)
+			return
+		}
+
+		id, err := app.readIDParam(ps)
+		if err != nil || id != share.DeckID {
+			app.invalidShareTokenResponse(w, r)
+			return
+		}
+
+		deck, err := app.models.Decks.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if deck.ShareVersion != share.Version {
+			app.invalidShareTokenResponse(w, r)
+			return
+		}
+
+		r = app.contextSetShare(r, share)
+		r = app.contextSetDeck(r, deck)
+
+		next(w, r, ps)
+	}
+}
+
+// requirePermission only lets requests holding the permission through.
+// Requests made with a share token hold the permissions of its role, those
+// made with an API key the key's permissions, authenticated users their own
+// and anonymous clients all of them; requireDeckOwner, which every route on a
+// single deck goes through, keeps anonymous clients off owned decks.
+func (app *application) requirePermission(code string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var permissions data.Permissions
+
+		user := app.contextGetUser(r)
+		key := app.contextGetAPIKey(r)
+
+		switch share := app.contextGetShare(r); {
+		case share != nil:
+			permissions = share.Permissions()
+		case key != nil:
+			permissions = key.Permissions
+		case user.IsAnonymous():
+			permissions = data.AllPermissions
+		default:
+			var err error
+
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+// requireDeckOwner only lets the owner of the deck in the :id parameter, or
+// the holder of a share token for it, through. Decks without an owner are
+// open to everyone. The deck it checked is put on the request context, so the
+// handler doesn't have to fetch it again.
+func (app *application) requireDeckOwner(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// authenticateShare has already checked the token is for this deck
+		// and put the deck on the context.
+		if app.contextGetShare(r) != nil {
+			next(w, r, ps)
+			return
+		}
+
+		id, err := app.readIDParam(ps)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		deck, err := app.models.Decks.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		if !user.Owns(deck) {
+			if user.IsAnonymous() {
+				app.authenticationRequiredResponse(w, r)
+			} else {
+				app.notPermittedResponse(w, r)
+			}
+			return
+		}
+
+		r = app.contextSetDeck(r, deck)
+
+		next(w, r, ps)
+	}
+}
+
+// requestID gives every request an ID, taken from the X-Request-ID header when
+// the client sent a valid one, and echoes it back in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// statusRecorder records the status code and size of a response for the
+// access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		app.logger.PrintInfo("request", map[string]string{
+			"request_id": app.contextGetRequestID(r),
+			"method":     r.Method,
+			"url":        redactedURL(r),
+			"status":     strconv.Itoa(rec.status),
+			"bytes":      strconv.Itoa(rec.bytes),
+			"latency":    time.Since(start).String(),
+		})
+	})
+}
+
+// metrics counts requests, responses by status code and the time spent
+// processing them.
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		totalRequestsReceived.Add(1)
+
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(rec.status), 1)
+		totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
+	})
+}
+
+// requireMetricsAccess only lets clients in the metrics allowlist through.
+func (app *application) requireMetricsAccess(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ip, err := app.clientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		for _, prefix := range app.config.metrics.allowlist {
+			if prefix.Contains(addr.Unmap()) {
+				next(w, r, ps)
+				return
+			}
+		}
+
+		app.notPermittedResponse(w, r)
+	}
+}
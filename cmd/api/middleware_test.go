@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/internal/jsonlog"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	app.recoverPanic(next).ServeHTTP(rr, r)
+
+	json.NewDecoder(rr.Body).Decode(&errorResponse)
+
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+	assert.Equal(t, rr.Header().Get("Connection"), "close")
+	assert.Equal(t, errorResponse.Error, "the server encountered a problem and could not process your request")
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	var got string
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = app.contextGetRequestID(r)
+	})
+
+	t.Run("Honours a valid X-Request-ID", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-ID", "abc-123")
+
+		app.requestID(next).ServeHTTP(rr, r)
+
+		assert.Equal(t, got, "abc-123")
+		assert.Equal(t, rr.Header().Get("X-Request-ID"), "abc-123")
+	})
+
+	t.Run("Generates an ID when none or an invalid one is sent", func(t *testing.T) {
+		for _, header := range []string{"", "has spaces", strings.Repeat("a", 129)} {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Request-ID", header)
+
+			app.requestID(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, len(got), 32)
+			assert.Equal(t, rr.Header().Get("X-Request-ID"), got)
+		}
+	})
+}
+
+func TestLogRequest(t *testing.T) {
+	var logs bytes.Buffer
+
+	app := newTestApplication(t)
+	app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1/decks?share=secret-token&limit=5", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+
+	app.requestID(app.logRequest(next)).ServeHTTP(rr, r)
+
+	var entry struct {
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	err := json.NewDecoder(&logs).Decode(&entry)
+	assert.NilError(t, err)
+
+	assert.Equal(t, entry.Message, "request")
+	assert.Equal(t, entry.Properties["request_id"], "abc-123")
+	assert.Equal(t, entry.Properties["method"], http.MethodPost)
+	assert.Equal(t, entry.Properties["url"], "/v1/decks?limit=5&share=REDACTED")
+	assert.Equal(t, entry.Properties["status"], "418")
+	assert.Equal(t, entry.Properties["bytes"], "15")
+}
+
+func TestLogError(t *testing.T) {
+	var logs bytes.Buffer
+
+	app := newTestApplication(t)
+	app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/decks/abc?share=secret-token", nil)
+
+	app.serverErrorResponse(rr, r, errors.New("boom"))
+
+	assert.Equal(t, strings.Contains(logs.String(), "secret-token"), false)
+	assert.Equal(t, strings.Contains(logs.String(), `"request_url":"/v1/decks/abc?share=REDACTED"`), true)
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.shutdown = make(chan struct{})
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+
+	defer func() {
+		close(app.shutdown)
+		app.wg.Wait()
+	}()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := app.rateLimit(next)
+
+	request := func(remoteAddr string) int {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+
+		handler.ServeHTTP(rr, r)
+
+		return rr.Code
+	}
+
+	t.Run("Allows a burst and then answers with http.StatusTooManyRequests", func(t *testing.T) {
+		assert.Equal(t, request("192.0.2.1:1234"), http.StatusOK)
+		assert.Equal(t, request("192.0.2.1:1235"), http.StatusOK)
+		assert.Equal(t, request("192.0.2.1:1236"), http.StatusTooManyRequests)
+	})
+
+	t.Run("Keeps a separate bucket for every client", func(t *testing.T) {
+		assert.Equal(t, request("192.0.2.2:1234"), http.StatusOK)
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	for _, s := range []string{"10.0.0.0/8", "192.0.2.10"} {
+		prefix, err := parseTrustedProxy(s)
+		assert.NilError(t, err)
+
+		app.config.limiter.trustedProxies = append(app.config.limiter.trustedProxies, prefix)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor string
+		want          string
+	}{
+		{"Ignores X-Forwarded-For from untrusted peers", "198.51.100.7:1234", "203.0.113.1", "198.51.100.7"},
+		{"Uses X-Forwarded-For from a trusted proxy", "192.0.2.10:1234", "203.0.113.1", "203.0.113.1"},
+		{"Skips trusted proxies and forged entries", "10.1.2.3:1234", "1.1.1.1, 203.0.113.1, 10.0.0.5", "203.0.113.1"},
+		{"Falls back to the proxy without X-Forwarded-For", "10.1.2.3:1234", "", "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			if tt.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+
+			got, err := app.clientIP(r)
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("Counts requests and responses by status code", func(t *testing.T) {
+		requests := totalRequestsReceived.Value()
+		responses := totalResponsesSent.Value()
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		teapots := func() int64 {
+			if v, ok := totalResponsesSentByStatus.Get("418").(*expvar.Int); ok {
+				return v.Value()
+			}
+			return 0
+		}
+		before := teapots()
+
+		app.metrics(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, totalRequestsReceived.Value(), requests+1)
+		assert.Equal(t, totalResponsesSent.Value(), responses+1)
+		assert.Equal(t, teapots(), before+1)
+	})
+
+	request := func(urlPath, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		r.RemoteAddr = remoteAddr
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, r)
+
+		return rr
+	}
+
+	t.Run("Hides the endpoints when metrics are disabled", func(t *testing.T) {
+		assert.Equal(t, request("/metrics", "127.0.0.1:1234").Code, http.StatusNotFound)
+		assert.Equal(t, request("/debug/vars", "127.0.0.1:1234").Code, http.StatusNotFound)
+	})
+
+	app.config.metrics.enabled = true
+
+	t.Run("Hides the endpoints until an allowlist is set", func(t *testing.T) {
+		assert.Equal(t, request("/metrics", "127.0.0.1:1234").Code, http.StatusNotFound)
+		assert.Equal(t, request("/debug/vars", "127.0.0.1:1234").Code, http.StatusNotFound)
+	})
+
+	app.config.metrics.allowlist = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+	t.Run("Only serves clients in the allowlist", func(t *testing.T) {
+		assert.Equal(t, request("/metrics", "192.0.2.1:1234").Code, http.StatusForbidden)
+		assert.Equal(t, request("/debug/vars", "192.0.2.1:1234").Code, http.StatusForbidden)
+	})
+
+	t.Run("Serves expvar variables", func(t *testing.T) {
+		rr := request("/debug/vars", "127.0.0.1:1234")
+
+		var vars map[string]any
+		json.NewDecoder(rr.Body).Decode(&vars)
+
+		assert.Equal(t, rr.Code, http.StatusOK)
+		for _, name := range []string{"total_requests_received", "total_responses_sent_by_status", "total_decks_created", "total_cards_dealt", "goroutines"} {
+			_, ok := vars[name]
+			assert.Equal(t, ok, true)
+		}
+	})
+
+	t.Run("Serves Prometheus metrics", func(t *testing.T) {
+		rr := request("/metrics", "127.0.0.1:1234")
+		body := rr.Body.String()
+
+		assert.Equal(t, rr.Code, http.StatusOK)
+		assert.Equal(t, strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4"), true)
+		for _, line := range []string{"# TYPE cards_requests_total counter", "cards_decks_created_total ", "cards_cards_dealt_total ", `cards_responses_by_status_total{status="403"} `, "go_goroutines "} {
+			assert.Equal(t, strings.Contains(body, line), true)
+		}
+	})
+}
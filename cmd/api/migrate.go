@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/jsonlog"
+	"github.com/scchi/cards/internal/migrate"
+	"github.com/scchi/cards/migrations"
+)
+
+const migrateUsage = "usage: api migrate [-db-dsn=DSN] up | down N | down all | version | force N"
+
+// migrateCommand runs the migrate subcommand against the -db-dsn database:
+//
+//	migrate up        applies every pending migration
+//	migrate down N    reverts the latest N migrations
+//	migrate down all  reverts every migration, dropping all the data
+//	migrate version   prints the current version
+//	migrate force N   records N as the clean current version
+//
+// The database flags may come before or after the subcommand.
+func migrateCommand(cfg config, logger *jsonlog.Logger, args []string) error {
+	args, err := parseMigrateArgs(&cfg, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	// The arguments are checked before connecting, so a mistyped command
+	// never reaches the database.
+	var steps, version int
+
+	switch {
+	case args[0] == "up" && len(args) == 1, args[0] == "version" && len(args) == 1:
+	case args[0] == "down" && len(args) == 2:
+		// Reverting everything drops every table, users included, so it
+		// has to be asked for by name rather than by leaving N out.
+		if args[1] != "all" {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+	case args[0] == "force" && len(args) == 2:
+		version, err = strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New(migrateUsage)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db, migrations.API, "api")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		logMigrations(logger, "migration applied", applied)
+		return err
+	case "down":
+		reverted, err := m.Down(ctx, steps)
+		logMigrations(logger, "migration reverted", reverted)
+		return err
+	case "version":
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+
+		return nil
+	default:
+		return m.Force(ctx, version)
+	}
+}
+
+// parseMigrateArgs parses the database flags found anywhere among the migrate
+// arguments into cfg, on top of those given before the subcommand, and
+// returns the remaining arguments in order.
+func parseMigrateArgs(cfg *config, args []string) ([]string, error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", cfg.db.dsn, "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", cfg.db.maxOpenConns, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", cfg.db.maxIdleConns, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", cfg.db.maxIdleTime, "PostgreSQL max connection idle time")
+
+	var rest []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w\n%s", err, migrateUsage)
+		}
+
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func logMigrations(logger *jsonlog.Logger, message string, applied []migrate.Migration) {
+	for _, migration := range applied {
+		logger.PrintInfo(message, map[string]string{"migration": migration.String()})
+	}
+}
+
+// checkSchema applies pending migrations when -auto-migrate is set, then
+// refuses to go on unless the database is at a schema version the code runs
+// against.
+func checkSchema(cfg config, db *sql.DB, logger *jsonlog.Logger) error {
+	m, err := migrate.New(db, migrations.API, "api")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if cfg.db.autoMigrate {
+		applied, err := m.Up(ctx)
+		logMigrations(logger, "migration applied", applied)
+		if err != nil {
+			return err
+		}
+	}
+
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("database schema version %d is dirty, fix it by hand and run \"migrate force\"", version)
+	case !data.SchemaSupported(version, dirty):
+		return fmt.Errorf("database schema version %d is not supported, expected %d to %d (run \"migrate up\" or start with -auto-migrate)", version, data.MinSchemaVersion, data.SchemaVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestParseMigrateArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		dsn  string
+		rest string
+	}{
+		{"Flags before the action", []string{"-db-dsn=postgres://b", "up"}, "postgres://b", "up"},
+		{"Flags after the action", []string{"up", "-db-dsn=postgres://b"}, "postgres://b", "up"},
+		{"Flags between the arguments", []string{"down", "-db-dsn", "postgres://b", "2"}, "postgres://b", "down 2"},
+		{"Keeps the DSN given before migrate", []string{"version"}, "postgres://a", "version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.db.dsn = "postgres://a"
+
+			rest, err := parseMigrateArgs(&cfg, tt.args)
+			assert.NilError(t, err)
+			assert.Equal(t, cfg.db.dsn, tt.dsn)
+			assert.Equal(t, strings.Join(rest, " "), tt.rest)
+		})
+	}
+
+	t.Run("Rejects unknown flags", func(t *testing.T) {
+		var cfg config
+
+		_, err := parseMigrateArgs(&cfg, []string{"up", "-verbose"})
+		assert.Equal(t, err != nil, true)
+	})
+}
+
+func TestMigrateCommand(t *testing.T) {
+	var cfg config
+	cfg.db.maxIdleTime = "15m"
+
+	t.Run("Only reverts every migration when asked for all", func(t *testing.T) {
+		for _, args := range [][]string{{"down"}, {"down", "0"}, {"down", "everything"}} {
+			err := migrateCommand(cfg, nil, args)
+			assert.Equal(t, err != nil && err.Error() == migrateUsage, true)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/validator"
+)
+
+func (app *application) dealToPileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		Count int `json:"count"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	name := app.readPileParam(ps)
+
+	v := validator.New()
+
+	data.ValidatePileName(v, name)
+	if app.validateCount(v, input.Count); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pile, err := app.models.Piles.Deal(r.Context(), id, name, input.Count, app.newEvent(r, data.EventDraw, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDeckClosed):
+			app.deckClosedResponse(w, r)
+		case errors.Is(err, data.ErrNotEnoughCards):
+			app.failedValidationResponse(w, r, map[string]string{"deck": "has less cards than requested"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	totalCardsDealt.Add(int64(input.Count))
+
+	app.prepForPileResponse(pile)
+
+	err = app.writeJSON(w, http.StatusOK, pile, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	pile, err := app.models.Piles.Get(r.Context(), id, app.readPileParam(ps))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.prepForPileResponse(pile)
+
+	err = app.writeJSON(w, http.StatusOK, pile, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) drawFromPileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		Count int         `json:"count"`
+		From  string      `json:"from"`
+		Cards []data.Card `json:"cards"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	sel := data.PileSelection{
+		Count:    input.Count,
+		Position: input.From,
+		Cards:    input.Cards,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePileSelection(v, sel); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cards, err := app.models.Piles.Draw(r.Context(), id, app.readPileParam(ps), sel, app.newEvent(r, data.EventPileDraw, nil))
+	if err != nil {
+		app.pileTransferErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string][]data.Card{"cards": cards}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) movePileCardsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		To    string      `json:"to"`
+		Count int         `json:"count"`
+		From  string      `json:"from"`
+		Cards []data.Card `json:"cards"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	name := app.readPileParam(ps)
+
+	sel := data.PileSelection{
+		Count:    input.Count,
+		Position: input.From,
+		Cards:    input.Cards,
+	}
+
+	v := validator.New()
+
+	v.Check(validator.Matches(input.To, data.PileNameRX), "to", "must be 1 to 32 lowercase letters, digits, dashes or underscores")
+	v.Check(input.To != name, "to", "must be a different pile")
+	if data.ValidatePileSelection(v, sel); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pile, err := app.models.Piles.Move(r.Context(), id, name, input.To, sel, app.newEvent(r, data.EventPileMove, nil))
+	if err != nil {
+		app.pileTransferErrorResponse(w, r, err)
+		return
+	}
+
+	app.prepForPileResponse(pile)
+
+	err = app.writeJSON(w, http.StatusOK, pile, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
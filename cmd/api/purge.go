@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// startPurgeWorker regularly deletes expired and long-idle decks in the
+// background until the server shuts down. Expired decks already look deleted
+// to every handler, so the worker only reclaims their rows.
+func (app *application) startPurgeWorker() {
+	if app.config.purge.interval <= 0 || app.config.purge.batchSize <= 0 {
+		return
+	}
+
+	app.background(func() {
+		ticker := time.NewTicker(app.config.purge.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				app.purgeDecks()
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+}
+
+// purgeDecks deletes batches of decks until a batch comes back short, so a
+// large backlog is cleared without holding long locks.
+func (app *application) purgeDecks() {
+	var total int64
+
+	for {
+		count, err := app.models.Decks.Purge(context.Background(), app.config.purge.idleTimeout, app.config.purge.batchSize)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			break
+		}
+
+		total += count
+
+		if count < int64(app.config.purge.batchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		app.logger.PrintInfo("purged decks", map[string]string{
+			"count": strconv.FormatInt(total, 10),
+		})
+	}
+}
@@ -1,20 +1,61 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.GET("/v1/healthcheck", app.healthcheckHandler)
-	router.POST("/v1/decks", app.createDeckHandler)
-	router.GET("/v1/decks/:id", app.showDeckHandler)
-	router.PUT("/v1/decks/:id", app.drawCardsHandler)
-	return router
+	router.GET("/v1/healthcheck/live", app.healthcheckHandler)
+	router.GET("/v1/healthcheck/ready", app.readinessHandler)
+	router.GET("/v1/decks", app.requirePermission(data.PermissionDecksRead, app.listDecksHandler))
+	router.POST("/v1/decks", app.requirePermission(data.PermissionDecksDeal, app.createDeckHandler))
+	router.GET("/v1/decks/:id", app.authenticateShare(app.requirePermission(data.PermissionDecksRead, app.requireDeckOwner(app.showDeckHandler))))
+	router.PUT("/v1/decks/:id", app.authenticateShare(app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.drawCardsHandler))))
+	router.DELETE("/v1/decks/:id", app.requirePermission(data.PermissionDecksAdmin, app.requireDeckOwner(app.deleteDeckHandler)))
+	router.POST("/v1/decks/:id/return", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.returnCardsHandler)))
+	router.POST("/v1/decks/:id/shuffle", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.shuffleDeckHandler)))
+	router.POST("/v1/decks/:id/undo", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.undoDrawsHandler)))
+	router.POST("/v1/decks/:id/close", app.requirePermission(data.PermissionDecksAdmin, app.requireDeckOwner(app.closeDeckHandler)))
+	router.GET("/v1/decks/:id/reveal", app.authenticateShare(app.requirePermission(data.PermissionDecksRead, app.requireDeckOwner(app.revealDeckHandler))))
+	router.GET("/v1/decks/:id/events", app.authenticateShare(app.requirePermission(data.PermissionDecksRead, app.requireDeckOwner(app.listDeckEventsHandler))))
+	router.POST("/v1/decks/:id/shares", app.requirePermission(data.PermissionDecksAdmin, app.requireDeckOwner(app.createShareTokenHandler)))
+	router.DELETE("/v1/decks/:id/shares", app.requirePermission(data.PermissionDecksAdmin, app.requireDeckOwner(app.revokeShareTokensHandler)))
+
+	router.POST("/v1/decks/:id/piles/:pile", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.dealToPileHandler)))
+	router.GET("/v1/decks/:id/piles/:pile", app.authenticateShare(app.requirePermission(data.PermissionDecksRead, app.requireDeckOwner(app.showPileHandler))))
+	router.PUT("/v1/decks/:id/piles/:pile", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.drawFromPileHandler)))
+	router.POST("/v1/decks/:id/piles/:pile/move", app.requirePermission(data.PermissionDecksDeal, app.requireDeckOwner(app.movePileCardsHandler)))
+
+	// Accounts need a storage backend that keeps users.
+	if app.models.Users != nil {
+		router.POST("/v1/users", app.registerUserHandler)
+		router.PUT("/v1/users/activated", app.activateUserHandler)
+
+		router.POST("/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+		router.GET("/v1/api-keys", app.requireActivatedUser(app.listAPIKeysHandler))
+		router.POST("/v1/api-keys", app.requireActivatedUser(app.rejectAPIKeys(app.createAPIKeyHandler)))
+		router.DELETE("/v1/api-keys/:id", app.requireActivatedUser(app.rejectAPIKeys(app.revokeAPIKeyHandler)))
+	}
+
+	// Metrics stay off until an allowlist is set. Behind a reverse proxy on
+	// the same host every request comes from loopback, so no default is safe.
+	if app.config.metrics.enabled && len(app.config.metrics.allowlist) > 0 {
+		router.GET("/debug/vars", app.requireMetricsAccess(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			expvar.Handler().ServeHTTP(w, r)
+		}))
+		router.GET("/metrics", app.requireMetricsAccess(app.prometheusHandler))
+	}
+
+	return app.recoverPanic(app.metrics(app.requestID(app.logRequest(app.rateLimit(app.authenticate(router))))))
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/internal/validator"
+)
+
+func (app *application) createShareTokenHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input struct {
+		Role string `json:"role"`
+		TTL  int    `json:"ttl"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.TTL == 0 {
+		input.TTL = 24 * 60 * 60
+	}
+
+	v := validator.New()
+
+	data.ValidateShareRole(v, input.Role)
+	if data.ValidateTTL(v, input.TTL); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deck := app.contextGetDeck(r)
+
+	share := data.NewShareToken(deck, input.Role, time.Duration(input.TTL)*time.Second, []byte(app.config.share.secret))
+
+	err = app.writeJSON(w, http.StatusCreated, map[string]*data.ShareToken{"share_token": share}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeShareTokensHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Decks.RevokeShares(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]string{"message": "share tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
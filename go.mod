@@ -5,4 +5,6 @@ go 1.18
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.9.0
+	golang.org/x/time v0.3.0
 )
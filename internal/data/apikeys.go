@@ -0,0 +1,241 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+)
+
+// APIKeyPrefix starts every API key, which makes leaked keys easy to spot.
+const APIKeyPrefix = "ck_"
+
+// APIKey is a long-lived credential for a service client. It acts for the
+// user who created it, limited to its own permissions. Like tokens, only the
+// SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// NewAPIKey generates a key for the user with the given name and permissions.
+func NewAPIKey(userID int64, name string, permissions Permissions) (*APIKey, error) {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Plaintext:   APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+	}
+
+	hash := hashToken(key.Plaintext)
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	ValidatePermissions(v, key.Permissions)
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, APIKeyPrefix), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 35 bytes long")
+}
+
+// -------------------------------------------------
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert saves the key together with its permissions.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (user_id, name, hash)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	err = tx.QueryRow(query, key.UserID, key.Name, key.Hash).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.Exec(query, key.ID, pq.Array(key.Permissions))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetForKey returns the API key with the given plaintext.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := hashToken(plaintext)
+
+	query := `
+		SELECT api_keys.id, api_keys.user_id, api_keys.name, api_keys.created_at,
+			array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+		FROM api_keys
+		LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+		LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id
+		WHERE api_keys.hash = $1
+		GROUP BY api_keys.id`
+
+	var key APIKey
+
+	err := m.DB.QueryRow(query, hash[:]).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.CreatedAt,
+		pq.Array(&key.Permissions),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT api_keys.id, api_keys.user_id, api_keys.name, api_keys.created_at,
+			array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+		FROM api_keys
+		LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+		LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id
+		WHERE api_keys.user_id = $1
+		GROUP BY api_keys.id
+		ORDER BY api_keys.id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.CreatedAt,
+			pq.Array(&key.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Delete revokes the user's API key with the given id.
+func (m APIKeyModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// -------------------------------------------------
+
+// MockAPIKeyModel knows a single read-only key, MockAPIKey, of the mock user.
+type MockAPIKeyModel struct{}
+
+var MockAPIKey = APIKeyPrefix + "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+func (m MockAPIKeyModel) mockKey() *APIKey {
+	return &APIKey{
+		ID:          1,
+		UserID:      MockUserID,
+		Name:        "overlay",
+		Permissions: Permissions{PermissionDecksRead},
+	}
+}
+
+func (m MockAPIKeyModel) Insert(key *APIKey) error {
+	key.ID = 2
+	return nil
+}
+
+func (m MockAPIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	if plaintext != MockAPIKey {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.mockKey(), nil
+}
+
+func (m MockAPIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	if userID != MockUserID {
+		return []*APIKey{}, nil
+	}
+
+	return []*APIKey{m.mockKey()}, nil
+}
+
+func (m MockAPIKeyModel) Delete(id, userID int64) error {
+	if id != 1 || userID != MockUserID {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -1,82 +1,306 @@
 package data
 
 import (
+	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"sort"
+	"strings"
+	"unicode/utf8"
 )
 
-type Card string
+type Suit uint8
 
-var suits = map[string]string{
-	"S": "SPADES",
-	"D": "DIAMONDS",
-	"C": "CLUBS",
-	"H": "HEARTS",
+const (
+	Spades Suit = iota + 1
+	Diamonds
+	Clubs
+	Hearts
+)
+
+var suits = []Suit{Spades, Diamonds, Clubs, Hearts}
+
+var suitNames = map[Suit]string{
+	Spades:   "SPADES",
+	Diamonds: "DIAMONDS",
+	Clubs:    "CLUBS",
+	Hearts:   "HEARTS",
 }
 
-var values = map[string]string{
-	"A": "ACE",
-	"J": "JACK",
-	"Q": "QUEEN",
-	"K": "KING",
+var suitCodes = map[Suit]string{
+	Spades:   "S",
+	Diamonds: "D",
+	Clubs:    "C",
+	Hearts:   "H",
 }
 
-func (c Card) MarshalJSON() ([]byte, error) {
-	value := c.GetValue()
-	suit := c.GetSuit()
+// suitSymbols maps every accepted suit spelling, including the Unicode
+// symbols, to its Suit.
+var suitSymbols = map[string]Suit{
+	"S": Spades, "♠": Spades, "♤": Spades,
+	"D": Diamonds, "♦": Diamonds, "♢": Diamonds,
+	"C": Clubs, "♣": Clubs, "♧": Clubs,
+	"H": Hearts, "♥": Hearts, "♡": Hearts,
+}
+
+func (s Suit) Valid() bool {
+	_, ok := suitNames[s]
+	return ok
+}
+
+func (s Suit) String() string {
+	return suitNames[s]
+}
+
+type Rank uint8
+
+const (
+	Ace Rank = iota + 1
+	Two
+	Three
+	Four
+	Five
+	Six
+	Seven
+	Eight
+	Nine
+	Ten
+	Jack
+	Queen
+	King
+)
+
+var ranks = []Rank{Ace, Two, Three, Four, Five, Six, Seven, Eight, Nine, Ten, Jack, Queen, King}
+
+var rankCodes = map[Rank]string{
+	Ace: "A", Two: "2", Three: "3", Four: "4", Five: "5", Six: "6", Seven: "7",
+	Eight: "8", Nine: "9", Ten: "10", Jack: "J", Queen: "Q", King: "K",
+}
+
+var rankNames = map[Rank]string{
+	Ace:   "ACE",
+	Jack:  "JACK",
+	Queen: "QUEEN",
+	King:  "KING",
+}
+
+// rankSymbols maps every accepted rank spelling to its Rank. "T" is accepted
+// as an alternative to "10".
+var rankSymbols = map[string]Rank{
+	"A": Ace, "2": Two, "3": Three, "4": Four, "5": Five, "6": Six, "7": Seven,
+	"8": Eight, "9": Nine, "10": Ten, "T": Ten, "J": Jack, "Q": Queen, "K": King,
+}
+
+func (r Rank) Valid() bool {
+	_, ok := rankCodes[r]
+	return ok
+}
 
-	jsonValue := map[string]string{
-		"value": value,
-		"suit":  suit,
-		"code":  string(c),
+// String returns the value of the rank as it appears in API responses, i.e.
+// "ACE", "2", ..., "10", "JACK", "QUEEN", "KING".
+func (r Rank) String() string {
+	if name, ok := rankNames[r]; ok {
+		return name
 	}
 
-	js, _ := json.Marshal(jsonValue)
+	return rankCodes[r]
+}
+
+type Card struct {
+	Rank Rank
+	Suit Suit
+}
 
-	return js, nil
+type CardError struct {
+	Code   string
+	Reason string
 }
 
-func GenerateCards(stringCards []string) []Card {
-	var result []Card
+func (e *CardError) Error() string {
+	return fmt.Sprintf("invalid card %q: %s", e.Code, e.Reason)
+}
 
-	for _, card := range stringCards {
-		result = append(result, Card(card))
+// ParseCard parses a card code such as "AS", "10H", "TH", "qd" or "A♠". The
+// rank comes first and the suit last.
+func ParseCard(code string) (Card, error) {
+	s := strings.ToUpper(strings.TrimSpace(code))
+	if s == "" {
+		return Card{}, &CardError{Code: code, Reason: "must not be empty"}
 	}
 
-	return result
+	suitSymbol, size := utf8.DecodeLastRuneInString(s)
+	if len(s) == size {
+		return Card{}, &CardError{Code: code, Reason: "must contain a rank and a suit"}
+	}
+
+	suit, ok := suitSymbols[string(suitSymbol)]
+	if !ok {
+		return Card{}, &CardError{Code: code, Reason: fmt.Sprintf("unknown suit %q", string(suitSymbol))}
+	}
+
+	rankSymbol := s[:len(s)-size]
+	rank, ok := rankSymbols[rankSymbol]
+	if !ok {
+		return Card{}, &CardError{Code: code, Reason: fmt.Sprintf("unknown rank %q", rankSymbol)}
+	}
+
+	return Card{Rank: rank, Suit: suit}, nil
 }
 
-func GenerateAllCards() []Card {
-	var result []Card
+// ParseCards parses every code in codes, stopping at the first invalid one.
+func ParseCards(codes []string) ([]Card, error) {
+	result := make([]Card, 0, len(codes))
+
+	for _, code := range codes {
+		card, err := ParseCard(code)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, card)
+	}
 
-	values := []string{
-		"A", "2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K",
+	return result, nil
+}
+
+// equalCards reports whether a and b hold the same cards in the same order.
+func equalCards(a, b []Card) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	suits := []string{
-		"S", "D", "C", "H",
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
 
+	return true
+}
+
+func (c Card) Valid() bool {
+	return c.Rank.Valid() && c.Suit.Valid()
+}
+
+// Code returns the canonical code of the card, e.g. "AS" or "10H".
+func (c Card) Code() string {
+	return rankCodes[c.Rank] + suitCodes[c.Suit]
+}
+
+func (c Card) String() string {
+	return c.Code()
+}
+
+func (c Card) MarshalJSON() ([]byte, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("cannot marshal invalid card (rank %d, suit %d)", c.Rank, c.Suit)
+	}
+
+	jsonValue := struct {
+		Value string `json:"value"`
+		Suit  string `json:"suit"`
+		Code  string `json:"code"`
+	}{
+		Value: c.Rank.String(),
+		Suit:  c.Suit.String(),
+		Code:  c.Code(),
+	}
+
+	return json.Marshal(jsonValue)
+}
+
+// UnmarshalJSON accepts either a card code string or the object produced by
+// MarshalJSON, in which case only the code field is used.
+func (c *Card) UnmarshalJSON(js []byte) error {
+	var code string
+
+	if bytes.HasPrefix(bytes.TrimSpace(js), []byte("{")) {
+		var aux struct {
+			Code string `json:"code"`
+		}
+
+		if err := json.Unmarshal(js, &aux); err != nil {
+			return err
+		}
+		code = aux.Code
+	} else if err := json.Unmarshal(js, &code); err != nil {
+		return err
+	}
+
+	card, err := ParseCard(code)
+	if err != nil {
+		return err
+	}
+
+	*c = card
+	return nil
+}
+
+// Value stores the card as its canonical code, which lets a []Card be written
+// with pq.Array.
+func (c Card) Value() (driver.Value, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("cannot store invalid card (rank %d, suit %d)", c.Rank, c.Suit)
+	}
+
+	return c.Code(), nil
+}
+
+// Scan reads a card code back from the database, which lets a []Card be read
+// with pq.Array.
+func (c *Card) Scan(src any) error {
+	var code string
+
+	switch v := src.(type) {
+	case string:
+		code = v
+	case []byte:
+		code = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Card", src)
+	}
+
+	card, err := ParseCard(code)
+	if err != nil {
+		return err
+	}
+
+	*c = card
+	return nil
+}
+
+func GenerateAllCards() []Card {
+	result := make([]Card, 0, len(suits)*len(ranks))
+
 	for _, suit := range suits {
-		for _, value := range values {
-			code := fmt.Sprintf("%s%s", value, suit)
-			card := Card(code)
-			result = append(result, card)
+		for _, rank := range ranks {
+			result = append(result, Card{Rank: rank, Suit: suit})
 		}
 	}
 
 	return result
 }
 
-func (c Card) GetSuit() string {
-	return suits[string(c[1])]
+// SortCards puts cards in the order of a new deck, suit by suit and ace to
+// king, keeping repeated cards of a shoe together.
+func SortCards(cards []Card) {
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].Suit != cards[j].Suit {
+			return cards[i].Suit < cards[j].Suit
+		}
+
+		return cards[i].Rank < cards[j].Rank
+	})
 }
 
-func (c Card) GetValue() string {
-	value := string(c[0])
-	if number, _ := strconv.Atoi(value); number == 0 {
-		value = values[value]
+// GenerateShoe returns deckCount full decks, one after the other.
+func GenerateShoe(deckCount int) []Card {
+	result := make([]Card, 0, len(suits)*len(ranks)*deckCount)
+
+	for i := 0; i < deckCount; i++ {
+		result = append(result, GenerateAllCards()...)
 	}
 
-	return value
+	return result
 }
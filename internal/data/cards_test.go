@@ -0,0 +1,98 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestParseCard(t *testing.T) {
+	t.Run("Parses every accepted spelling of a card", func(t *testing.T) {
+		tests := []struct {
+			code string
+			want Card
+		}{
+			{"AS", Card{Rank: Ace, Suit: Spades}},
+			{"10H", Card{Rank: Ten, Suit: Hearts}},
+			{"TH", Card{Rank: Ten, Suit: Hearts}},
+			{"th", Card{Rank: Ten, Suit: Hearts}},
+			{"qd", Card{Rank: Queen, Suit: Diamonds}},
+			{"A♠", Card{Rank: Ace, Suit: Spades}},
+			{"10♥", Card{Rank: Ten, Suit: Hearts}},
+			{"7♧", Card{Rank: Seven, Suit: Clubs}},
+		}
+
+		for _, tt := range tests {
+			got, err := ParseCard(tt.code)
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		}
+	})
+
+	t.Run("Returns a CardError for invalid codes", func(t *testing.T) {
+		codes := []string{"", "A", "RR", "ZZ", "1S", "11S", "AX", "♠"}
+
+		for _, code := range codes {
+			_, err := ParseCard(code)
+
+			var cardErr *CardError
+			if !errors.As(err, &cardErr) {
+				t.Errorf("expected CardError for %q, got %v", code, err)
+			}
+		}
+	})
+}
+
+func TestCardJSON(t *testing.T) {
+	t.Run("Every card in a full deck round-trips through JSON", func(t *testing.T) {
+		for _, card := range GenerateAllCards() {
+			js, err := json.Marshal(card)
+			assert.NilError(t, err)
+
+			var got Card
+			err = json.Unmarshal(js, &got)
+			assert.NilError(t, err)
+			assert.Equal(t, got, card)
+		}
+	})
+
+	t.Run("Tens are marshalled with a value of 10 and a suit", func(t *testing.T) {
+		js, err := json.Marshal(Card{Rank: Ten, Suit: Spades})
+		assert.NilError(t, err)
+		assert.Equal(t, string(js), `{"value":"10","suit":"SPADES","code":"10S"}`)
+	})
+
+	t.Run("Unmarshals card codes", func(t *testing.T) {
+		var cards []Card
+		err := json.Unmarshal([]byte(`["AS", "T♦", "kc"]`), &cards)
+		assert.NilError(t, err)
+
+		assert.Equal(t, len(cards), 3)
+		assert.Equal(t, cards[0].Code(), "AS")
+		assert.Equal(t, cards[1].Code(), "10D")
+		assert.Equal(t, cards[2].Code(), "KC")
+	})
+}
+
+func TestCardScan(t *testing.T) {
+	for _, card := range GenerateAllCards() {
+		value, err := card.Value()
+		assert.NilError(t, err)
+
+		var got Card
+		err = got.Scan([]byte(value.(string)))
+		assert.NilError(t, err)
+		assert.Equal(t, got, card)
+	}
+}
+
+func TestSortCards(t *testing.T) {
+	cards, err := ParseCards([]string{"KH", "2C", "AS", "10D", "AS", "3S"})
+	assert.NilError(t, err)
+
+	SortCards(cards)
+
+	assert.Equal(t, codes(cards), "AS AS 3S 10D 2C KH")
+}
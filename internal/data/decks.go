@@ -1,69 +1,258 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"math/rand"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
 	"github.com/scchi/cards/internal/validator"
 )
 
+const (
+	CardsPerDeck = 52
+	MaxDeckCount = 8
+	MaxCards     = CardsPerDeck * MaxDeckCount
+
+	// MaxTTL is the longest time, in seconds, a deck can be created to live.
+	MaxTTL = 30 * 24 * 60 * 60
+)
+
 type Deck struct {
-	ID          string    `json:"deck_id"`
-	Shuffled    bool      `json:"shuffled"`
-	Remaining   int       `json:"remaining"`
-	Cards       []Card    `json:"cards,omitempty"`
-	StringCards []string  `json:"-"`
-	CreatedAt   time.Time `json:"-"`
-	Version     int       `json:"-"`
+	ID               string         `json:"deck_id"`
+	OwnerID          *int64         `json:"-"`
+	Shuffled         bool           `json:"shuffled"`
+	ShuffleAlgorithm string         `json:"shuffle_algorithm,omitempty"`
+	ShuffleSeed      []byte         `json:"-"`
+	Commitment       string         `json:"commitment,omitempty"`
+	Secret           []byte         `json:"-"`
+	Closed           bool           `json:"closed"`
+	DeckCount        int            `json:"deck_count"`
+	Remaining        int            `json:"remaining"`
+	Cards            []Card         `json:"cards,omitempty"`
+	InitialCards     []Card         `json:"-"`
+	Piles            map[string]int `json:"piles,omitempty"`
+	Labels           Labels         `json:"labels,omitempty"`
+	ExpiresAt        *time.Time     `json:"expires_at,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	Version          int            `json:"version"`
+	ShareVersion     int            `json:"-"`
+}
+
+func ValidateDeckCount(v *validator.Validator, deckCount int) {
+	v.Check(deckCount > 0, "deck_count", "must be more than zero")
+	v.Check(deckCount <= MaxDeckCount, "deck_count", fmt.Sprintf("must be equal or less than %d", MaxDeckCount))
 }
 
+// ValidateCardsInput checks the cards of a deck built from deck.DeckCount
+// decks. Each card may appear once per deck.
 func ValidateCardsInput(v *validator.Validator, deck *Deck) {
-	v.Check(validator.Unique(deck.Cards), "cards", "must not contain duplicated values")
-	v.Check(len(deck.Cards) <= 52, "cards", "must not contain more than 52 cards")
+	if deck.DeckCount > 1 {
+		v.Check(validator.MaxRepeats(deck.Cards, deck.DeckCount), "cards", fmt.Sprintf("must not contain a card more than %d times", deck.DeckCount))
+	} else {
+		v.Check(validator.Unique(deck.Cards), "cards", "must not contain duplicated values")
+	}
+	v.Check(len(deck.Cards) <= CardsPerDeck*deck.DeckCount, "cards", fmt.Sprintf("must not contain more than %d cards", CardsPerDeck*deck.DeckCount))
+
+	for _, card := range deck.Cards {
+		v.Check(card.Valid(), "cards", "contains invalid card")
+	}
+}
+
+func ValidateTTL(v *validator.Validator, ttl int) {
+	v.Check(ttl > 0, "ttl", "must be more than zero")
+	v.Check(ttl <= MaxTTL, "ttl", fmt.Sprintf("must be equal or less than %d", MaxTTL))
+}
+
+// DeckFilters narrows down a list of decks. A nil Shuffled and zero
+// CreatedAfter or CreatedBefore don't filter. A nil OwnerID only matches
+// decks without an owner.
+type DeckFilters struct {
+	OwnerID       *int64
+	Shuffled      *bool
+	MinRemaining  int
+	MaxRemaining  int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Labels        Labels
+	Filters
+}
+
+var DeckSortSafelist = []string{"created_at", "remaining", "deck_count", "-created_at", "-remaining", "-deck_count"}
+
+func ValidateDeckFilters(v *validator.Validator, f DeckFilters) {
+	v.Check(f.MinRemaining >= 0, "remaining_min", "must not be negative")
+	v.Check(f.MaxRemaining <= MaxCards, "remaining_max", fmt.Sprintf("must be equal or less than %d", MaxCards))
+	v.Check(f.MinRemaining <= f.MaxRemaining, "remaining_min", "must not be more than remaining_max")
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() {
+		v.Check(f.CreatedAfter.Before(f.CreatedBefore), "created_after", "must be before created_before")
+	}
+
+	ValidateLabels(v, f.Labels)
+	ValidateFilters(v, f.Filters)
+}
+
+func ValidateShuffleAlgorithm(v *validator.Validator, algorithm string) {
+	v.Check(validator.PermittedValue(algorithm, ShuffleAlgorithms), "shuffle_algorithm", "must be fisher-yates, riffle or overhand")
+}
+
+func ValidateReturnInput(v *validator.Validator, cards []Card, position string) {
+	v.Check(len(cards) <= MaxCards, "cards", fmt.Sprintf("must not contain more than %d cards", MaxCards))
+	v.Check(validator.PermittedValue(position, []string{"", PositionTop, PositionBottom, PositionRandom}), "to", "must be top, bottom or random")
+}
+
+// outstandingCards returns the cards of initial that are in none of inPlay,
+// keeping the order of initial.
+func outstandingCards(initial []Card, inPlay ...[]Card) []Card {
+	counts := make(map[Card]int)
+
+	for _, cards := range inPlay {
+		for _, card := range cards {
+			counts[card]++
+		}
+	}
+
+	var result []Card
+
+	for _, card := range initial {
+		if counts[card] > 0 {
+			counts[card]--
+			continue
+		}
+
+		result = append(result, card)
+	}
+
+	return result
+}
+
+// returnCards puts cards back into remaining at position, drawing random
+// positions from src. A nil cards returns every outstanding card; otherwise
+// each card must be outstanding.
+func returnCards(remaining, outstanding, cards []Card, position string, src source) ([]Card, error) {
+	if cards == nil {
+		cards = outstanding
+	} else {
+		_, _, err := PileSelection{Cards: cards}.take(outstanding)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	permittedCards := GenerateAllCards()
-	v.Check(validator.PermittedValues(deck.Cards, permittedCards), "cards", "contains invalid card")
+	switch position {
+	case PositionBottom:
+		return append(append([]Card{}, remaining...), cards...), nil
+	case PositionRandom:
+		result := append([]Card{}, remaining...)
+
+		for _, card := range cards {
+			i := src.Intn(len(result) + 1)
+			result = append(result[:i], append([]Card{card}, result[i:]...)...)
+		}
+
+		return result, nil
+	default:
+		return append(append([]Card{}, cards...), remaining...), nil
+	}
 }
 
 // -------------------------------------------------
 
 type DeckModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func ShuffleDeck(deck *Deck) {
-	rand.Seed(time.Now().Unix())
+// Insert saves a new deck and records it as a create event in the same
+// transaction.
+func (d DeckModel) Insert(ctx context.Context, deck *Deck, event *Event) error {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
 
-	rand.Shuffle(len(deck.Cards), func(i, j int) {
-		deck.Cards[i], deck.Cards[j] = deck.Cards[j], deck.Cards[i]
-	})
-}
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-func (d DeckModel) Insert(deck *Deck) error {
 	query := `
-		INSERT INTO decks (shuffled, cards)
-		VALUES ($1, $2)
-		RETURNING id, created_at`
+		INSERT INTO decks (owner_id, shuffled, shuffle_algorithm, shuffle_seed, commitment, secret, deck_count, initial_cards, end_position, labels, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, expires_at, version`
+
+	deck.InitialCards = deck.Cards
+
+	args := []any{
+		deck.OwnerID,
+		deck.Shuffled,
+		deck.ShuffleAlgorithm,
+		deck.ShuffleSeed,
+		deck.Commitment,
+		deck.Secret,
+		deck.DeckCount,
+		pq.Array(deck.Cards),
+		len(deck.Cards),
+		deck.Labels,
+		deck.ExpiresAt,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&deck.ID, &deck.CreatedAt, &deck.ExpiresAt, &deck.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertDeckCards(ctx, tx, deck.ID, 0, deck.Cards)
+	if err != nil {
+		return err
+	}
+
+	// The create event leaves the cards out: the event log is public and
+	// would otherwise give away the order of a shuffled deck before it is
+	// revealed.
+	event.DeckID = deck.ID
+	event.Action = EventCreate
 
-	args := []any{deck.Shuffled, pq.Array(deck.Cards)}
-	return d.DB.QueryRow(query, args...).Scan(&deck.ID, &deck.CreatedAt)
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (d DeckModel) Get(id string) (*Deck, error) {
+// Get returns the deck with the given id. Expired decks are treated as
+// deleted even before they are purged.
+func (d DeckModel) Get(ctx context.Context, id string) (*Deck, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
 	query := `
-		SELECT id, shuffled, cards
+		SELECT id, owner_id, shuffled, shuffle_algorithm, shuffle_seed, commitment, secret, closed, deck_count, ` + remainingCards + `, initial_cards, labels, expires_at, created_at, version, share_version
 		FROM decks
-		WHERE id::text = $1`
+		WHERE id::text = $1 AND (expires_at IS NULL OR expires_at > NOW())`
 
 	var deck Deck
 
-	err := d.DB.QueryRow(query, id).Scan(
+	err := d.DB.QueryRowContext(ctx, query, id).Scan(
 		&deck.ID,
+		&deck.OwnerID,
 		&deck.Shuffled,
-		pq.Array(&deck.StringCards),
+		&deck.ShuffleAlgorithm,
+		&deck.ShuffleSeed,
+		&deck.Commitment,
+		&deck.Secret,
+		&deck.Closed,
+		&deck.DeckCount,
+		pq.Array(&deck.Cards),
+		pq.Array(&deck.InitialCards),
+		&deck.Labels,
+		&deck.ExpiresAt,
+		&deck.CreatedAt,
+		&deck.Version,
+		&deck.ShareVersion,
 	)
 
 	if err != nil {
@@ -75,22 +264,489 @@ func (d DeckModel) Get(id string) (*Deck, error) {
 		}
 	}
 
+	deck.Piles, err = d.pileSizes(ctx, deck.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &deck, nil
 }
 
-func (d DeckModel) Update(deck *Deck) error {
+// GetAll returns a page of the decks matching filters, without their cards.
+func (d DeckModel) GetAll(ctx context.Context, filters DeckFilters) ([]*Deck, Metadata, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, owner_id, shuffled, shuffle_algorithm, commitment, closed, deck_count,
+			end_position - next_position AS remaining, labels, expires_at, created_at, version
+		FROM decks
+		WHERE (expires_at IS NULL OR expires_at > NOW())
+		AND ($1::boolean IS NULL OR shuffled = $1)
+		AND end_position - next_position BETWEEN $2 AND $3
+		AND ($4::timestamptz IS NULL OR created_at >= $4)
+		AND ($5::timestamptz IS NULL OR created_at < $5)
+		AND labels @> $6::jsonb
+		AND owner_id IS NOT DISTINCT FROM $7
+		ORDER BY %s %s, id ASC
+		LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	var createdAfter, createdBefore *time.Time
+
+	if !filters.CreatedAfter.IsZero() {
+		createdAfter = &filters.CreatedAfter
+	}
+
+	if !filters.CreatedBefore.IsZero() {
+		createdBefore = &filters.CreatedBefore
+	}
+
+	args := []any{
+		filters.Shuffled,
+		filters.MinRemaining,
+		filters.MaxRemaining,
+		createdAfter,
+		createdBefore,
+		filters.Labels,
+		filters.OwnerID,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	decks := []*Deck{}
+
+	for rows.Next() {
+		var deck Deck
+
+		err := rows.Scan(
+			&totalRecords,
+			&deck.ID,
+			&deck.OwnerID,
+			&deck.Shuffled,
+			&deck.ShuffleAlgorithm,
+			&deck.Commitment,
+			&deck.Closed,
+			&deck.DeckCount,
+			&deck.Remaining,
+			&deck.Labels,
+			&deck.ExpiresAt,
+			&deck.CreatedAt,
+			&deck.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		decks = append(decks, &deck)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return decks, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (d DeckModel) pileSizes(ctx context.Context, id string) (map[string]int, error) {
+	query := `
+		SELECT name, coalesce(array_length(cards, 1), 0)
+		FROM piles
+		WHERE deck_id::text = $1`
+
+	rows, err := d.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	piles := make(map[string]int)
+
+	for rows.Next() {
+		var name string
+		var size int
+
+		err := rows.Scan(&name, &size)
+		if err != nil {
+			return nil, err
+		}
+
+		piles[name] = size
+	}
+
+	return piles, rows.Err()
+}
+
+// remainingCards selects the cards left in a deck, in order, from a query on
+// decks. The rows of deck_cards are never changed. The cards left are the ones
+// between the next_position and end_position cursors of the deck.
+const remainingCards = `ARRAY(
+			SELECT code
+			FROM deck_cards
+			WHERE deck_cards.deck_id = decks.id AND position >= decks.next_position AND position < decks.end_position
+			ORDER BY position
+		)`
+
+// lockDeck locks the deck row for the rest of tx. Queries that read deck_cards
+// must run after it rather than take the lock themselves: a statement that
+// waits for the lock sees the new cursors of the deck once it gets it, but
+// still reads deck_cards as they were when it started, without the cards the
+// transaction it waited for wrote.
+func lockDeck(ctx context.Context, tx *sql.Tx, id string) error {
+	query := `
+		SELECT id
+		FROM decks
+		WHERE id::text = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertDeckCards writes cards to deck_cards from position start on.
+func insertDeckCards(ctx context.Context, tx *sql.Tx, deckID string, start int, cards []Card) error {
+	query := `
+		INSERT INTO deck_cards (deck_id, position, code)
+		SELECT $1::uuid, $2 + c.n - 1, c.code
+		FROM unnest($3::varchar(3)[]) WITH ORDINALITY AS c(code, n)`
+
+	_, err := tx.ExecContext(ctx, query, deckID, start, pq.Array(cards))
+	return err
+}
+
+// saveDeckCards makes cards the cards left in the deck. When they are the
+// last cards written for the deck, which is the case after a draw or an undo,
+// only its next_position cursor moves. Otherwise they are appended after the
+// deck's cards and both cursors move to them.
+func saveDeckCards(ctx context.Context, tx *sql.Tx, deckID string, cards []Card) error {
+	query := `
+		SELECT end_position, ARRAY(
+			SELECT code
+			FROM deck_cards
+			WHERE deck_cards.deck_id = decks.id AND position >= decks.end_position - $2
+			ORDER BY position
+		)
+		FROM decks
+		WHERE id::text = $1`
+
+	var end int
+	var last []Card
+
+	err := tx.QueryRowContext(ctx, query, deckID, len(cards)).Scan(&end, pq.Array(&last))
+	if err != nil {
+		return err
+	}
+
+	if equalCards(last, cards) {
+		query = `
+			UPDATE decks
+			SET next_position = end_position - $2
+			WHERE id::text = $1`
+
+		_, err = tx.ExecContext(ctx, query, deckID, len(cards))
+		return err
+	}
+
+	err = insertDeckCards(ctx, tx, deckID, end, cards)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE decks
+		SET next_position = $2, end_position = $3
+		WHERE id::text = $1`
+
+	_, err = tx.ExecContext(ctx, query, deckID, end, end+len(cards))
+	return err
+}
+
+// Update saves the cards, shuffle state and closed flag of the deck and
+// records event, provided nobody else has changed the deck since it was read.
+// Otherwise it returns ErrEditConflict. A draw event is also kept as a Draw so
+// that it can be undone.
+func (d DeckModel) Update(ctx context.Context, deck *Deck, event *Event) error {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE decks
-		SET cards = $1, version = version + 1
-		WHERE id::text = $2
+		SET shuffled = $1, shuffle_algorithm = $2, shuffle_seed = $3, closed = $4, updated_at = NOW(), version = version + 1
+		WHERE id::text = $5 AND version = $6
 		RETURNING version`
 
 	args := []any{
-		pq.Array(deck.Cards),
+		deck.Shuffled,
+		deck.ShuffleAlgorithm,
+		deck.ShuffleSeed,
+		deck.Closed,
 		deck.ID,
+		deck.Version,
 	}
 
-	return d.DB.QueryRow(query, args...).Scan(&deck.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&deck.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = saveDeckCards(ctx, tx, deck.ID, deck.Cards)
+	if err != nil {
+		return err
+	}
+
+	event.DeckID = deck.ID
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	if event.Action == EventDraw {
+		err = insertDraw(ctx, tx, &Draw{DeckID: deck.ID, Cards: event.Cards, Version: deck.Version})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Return puts dealt cards back into the deck at position and records them as
+// a return event. Cards held in piles are not dealt, so they can't be
+// returned this way. A committed deck that may already have been revealed
+// can't take cards back either, since play would go on under a public secret,
+// so it returns ErrDeckRevealed.
+func (d DeckModel) Return(ctx context.Context, id string, cards []Card, position string, event *Event) (*Deck, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockDeck(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, shuffled, shuffle_algorithm, commitment, closed, deck_count, ` + remainingCards + `, initial_cards
+		FROM decks
+		WHERE id::text = $1`
+
+	var deck Deck
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&deck.ID,
+		&deck.Shuffled,
+		&deck.ShuffleAlgorithm,
+		&deck.Commitment,
+		&deck.Closed,
+		&deck.DeckCount,
+		pq.Array(&deck.Cards),
+		pq.Array(&deck.InitialCards),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if deck.Closed {
+		return nil, ErrDeckClosed
+	}
+
+	piles, err := pileCards(ctx, tx, deck.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	inPlay := [][]Card{deck.Cards}
+	deck.Piles = make(map[string]int, len(piles))
+	for name, cards := range piles {
+		inPlay = append(inPlay, cards)
+		deck.Piles[name] = len(cards)
+	}
+
+	if deck.Commitment != "" && deck.Revealable() {
+		return nil, ErrDeckRevealed
+	}
+
+	outstanding := outstandingCards(deck.InitialCards, inPlay...)
+	if cards == nil {
+		cards = outstanding
+	}
+
+	src, err := newCryptoSource()
+	if err != nil {
+		return nil, err
+	}
+
+	deck.Cards, err = returnCards(deck.Cards, outstanding, cards, position, src)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE decks
+		SET updated_at = NOW(), version = version + 1
+		WHERE id::text = $1
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, deck.ID).Scan(&deck.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveDeckCards(ctx, tx, deck.ID, deck.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	event.DeckID = deck.ID
+	event.Action = EventReturn
+	event.Cards = cards
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deck, tx.Commit()
+}
+
+// Delete removes the deck together with its piles and draws. The events of
+// the deck are kept, ending with a delete event.
+func (d DeckModel) Delete(ctx context.Context, id string, event *Event) error {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM decks
+		WHERE id::text = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING id`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&event.DeckID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	event.Action = EventDelete
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeShares invalidates every share token issued for the deck by bumping
+// its share version.
+func (d DeckModel) RevokeShares(ctx context.Context, id string) error {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE decks
+		SET share_version = share_version + 1, updated_at = NOW()
+		WHERE id::text = $1 AND (expires_at IS NULL OR expires_at > NOW())`
+
+	result, err := d.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Purge deletes up to limit decks that have expired or, when idle is more
+// than zero, haven't changed for longer than idle. Unlike Delete, it also
+// deletes the events of the decks, leaving a single purge event in their
+// place. It returns how many decks it deleted.
+func (d DeckModel) Purge(ctx context.Context, idle time.Duration, limit int) (int64, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	query := `
+		WITH purged AS (
+			DELETE FROM decks
+			WHERE id IN (
+				SELECT id
+				FROM decks
+				WHERE expires_at <= NOW() OR ($1::integer > 0 AND updated_at < NOW() - $1::integer * interval '1 second')
+				LIMIT $2
+			)
+			RETURNING id
+		), purged_events AS (
+			DELETE FROM deck_events
+			WHERE deck_id IN (SELECT id FROM purged)
+		), purge_events AS (
+			INSERT INTO deck_events (deck_id, action)
+			SELECT id, 'purge' FROM purged
+		)
+		SELECT COUNT(*) FROM purged`
+
+	var count int64
+
+	err := d.DB.QueryRowContext(ctx, query, int64(idle.Seconds()), limit).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
 }
 
 // -------------------------------------------------
@@ -99,17 +755,21 @@ type MockDeckModel struct{}
 
 var MockID = "a23d446a-f01a-4d6e-bec3-f928a3457ac7"
 var MockShuffled = true
-var MockCards = []string{
-	"AS",
-	"9D",
+var MockVersion = 2
+var MockCards = []Card{
+	{Rank: Ace, Suit: Spades},
+	{Rank: Nine, Suit: Diamonds},
+}
+var MockDealtCards = []Card{
+	{Rank: King, Suit: Hearts},
 }
 
-func (m MockDeckModel) Insert(deck *Deck) error {
+func (m MockDeckModel) Insert(ctx context.Context, deck *Deck, event *Event) error {
 	deck.ID = MockID
 	return nil
 }
 
-func (m MockDeckModel) Get(id string) (*Deck, error) {
+func (m MockDeckModel) Get(ctx context.Context, id string) (*Deck, error) {
 	if len(id) != 36 {
 		return nil, ErrRecordNotFound
 	}
@@ -119,14 +779,82 @@ func (m MockDeckModel) Get(id string) (*Deck, error) {
 	}
 
 	deck := Deck{
-		ID:          id,
-		Shuffled:    MockShuffled,
-		StringCards: MockCards,
+		ID:               id,
+		Shuffled:         MockShuffled,
+		ShuffleAlgorithm: DefaultShuffleAlgorithm,
+		DeckCount:        1,
+		Cards:            append([]Card{}, MockCards...),
+		InitialCards:     append(append([]Card{}, MockDealtCards...), MockCards...),
+		Version:          MockVersion,
+		ShareVersion:     1,
 	}
 
 	return &deck, nil
 }
 
-func (m MockDeckModel) Update(deck *Deck) error {
+func (m MockDeckModel) GetAll(ctx context.Context, filters DeckFilters) ([]*Deck, Metadata, error) {
+	deck, err := m.Get(ctx, MockID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	deck.Remaining = len(deck.Cards)
+	deck.Cards = nil
+
+	decks := []*Deck{}
+
+	if filters.OwnerID != nil {
+		return decks, Metadata{}, nil
+	}
+
+	if filters.Shuffled == nil || *filters.Shuffled == deck.Shuffled {
+		if deck.Remaining >= filters.MinRemaining && deck.Remaining <= filters.MaxRemaining {
+			decks = append(decks, deck)
+		}
+	}
+
+	return decks, calculateMetadata(len(decks), filters.Page, filters.PageSize), nil
+}
+
+func (m MockDeckModel) Update(ctx context.Context, deck *Deck, event *Event) error {
 	return nil
 }
+
+func (m MockDeckModel) Delete(ctx context.Context, id string, event *Event) error {
+	if id != MockID {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m MockDeckModel) RevokeShares(ctx context.Context, id string) error {
+	if id != MockID {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m MockDeckModel) Purge(ctx context.Context, idle time.Duration, limit int) (int64, error) {
+	return 0, nil
+}
+
+func (m MockDeckModel) Return(ctx context.Context, id string, cards []Card, position string, event *Event) (*Deck, error) {
+	deck, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := newCryptoSource()
+	if err != nil {
+		return nil, err
+	}
+
+	deck.Cards, err = returnCards(deck.Cards, MockDealtCards, cards, position, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return deck, nil
+}
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestReturnCards(t *testing.T) {
+	initial, err := ParseCards([]string{"AS", "2S", "3S", "4S", "5S"})
+	assert.NilError(t, err)
+
+	remaining := initial[3:]
+	pile := initial[2:3]
+
+	outstanding := outstandingCards(initial, remaining, pile)
+
+	src, err := newCryptoSource()
+	assert.NilError(t, err)
+	assert.Equal(t, codes(outstanding), "AS 2S")
+
+	t.Run("returns every outstanding card on top by default", func(t *testing.T) {
+		got, err := returnCards(remaining, outstanding, nil, "", src)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got), "AS 2S 4S 5S")
+	})
+
+	t.Run("returns specific cards to the bottom", func(t *testing.T) {
+		got, err := returnCards(remaining, outstanding, initial[1:2], PositionBottom, src)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got), "4S 5S 2S")
+	})
+
+	t.Run("returns cards to random positions", func(t *testing.T) {
+		got, err := returnCards(remaining, outstanding, nil, PositionRandom, src)
+		assert.NilError(t, err)
+		assert.Equal(t, len(got), 4)
+	})
+
+	t.Run("refuses cards that aren't outstanding", func(t *testing.T) {
+		_, err := returnCards(remaining, outstanding, initial[2:3], "", src)
+		assert.Equal(t, errors.Is(err, ErrCardsNotFound), true)
+	})
+}
@@ -0,0 +1,387 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+)
+
+const MaxUndoDraws = 10
+
+// Draw is a draw from the top of a deck that can still be undone. Version is
+// the version of the deck the draw produced.
+type Draw struct {
+	ID      int64
+	DeckID  string
+	Cards   []Card
+	Version int
+}
+
+func ValidateUndoDraws(v *validator.Validator, draws int) {
+	v.Check(draws > 0, "draws", "must be more than zero")
+	v.Check(draws <= MaxUndoDraws, "draws", fmt.Sprintf("must be equal or less than %d", MaxUndoDraws))
+}
+
+// checkDraw returns why count cards can't be drawn from a deck with remaining
+// cards, if they can't.
+func checkDraw(closed bool, remaining, count int) error {
+	switch {
+	case closed:
+		return ErrDeckClosed
+	case remaining == 0:
+		return ErrDeckDealt
+	case count > remaining:
+		return ErrNotEnoughCards
+	default:
+		return nil
+	}
+}
+
+// undoDraws puts the cards of draws, newest first, back on top of cards in
+// their original order. The draws must be the latest changes to the deck,
+// i.e. the newest one produced version and each older one the version before.
+func undoDraws(cards []Card, version int, draws []*Draw) ([]Card, error) {
+	if len(draws) == 0 {
+		return nil, ErrNothingToUndo
+	}
+
+	for i, draw := range draws {
+		if draw.Version != version-i {
+			return nil, ErrNothingToUndo
+		}
+	}
+
+	var result []Card
+
+	for i := len(draws) - 1; i >= 0; i-- {
+		result = append(result, draws[i].Cards...)
+	}
+
+	return append(result, cards...), nil
+}
+
+// -------------------------------------------------
+
+// Draw takes count cards off the top of the deck and records them as a draw
+// event, and as a Draw so that they can be undone. Drawing only moves the
+// deck's next_position cursor past the cards and reads them back from
+// deck_cards, however many cards the deck holds. The deck row is locked
+// first, so concurrent draws queue up behind each other instead of
+// conflicting, and the cards are read by a later statement that sees the
+// cards written by whatever held the lock before. It returns ErrDeckClosed,
+// ErrDeckDealt or ErrNotEnoughCards when the cards can't be drawn.
+func (d DeckModel) Draw(ctx context.Context, id string, count int, event *Event) (*Draw, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, next_position, end_position, closed
+		FROM decks
+		WHERE id::text = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		FOR UPDATE`
+
+	var draw Draw
+	var next, end int
+	var closed bool
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&draw.DeckID, &next, &end, &closed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = checkDraw(closed, end-next, count)
+	if err != nil {
+		return nil, err
+	}
+
+	// The UPDATE and the inserts run in the same statement, which reads
+	// deck_cards from a snapshot taken after the lock above was granted.
+	query = `
+		WITH drawn AS (
+			UPDATE decks
+			SET next_position = $2 + $3, updated_at = NOW(), version = version + 1
+			WHERE id = $1
+			RETURNING id, version, ARRAY(
+				SELECT code
+				FROM deck_cards
+				WHERE deck_id = $1 AND position >= $2 AND position < $2 + $3
+				ORDER BY position
+			) AS cards
+		), event AS (
+			INSERT INTO deck_events (deck_id, action, pile, cards, request_id)
+			SELECT id, $4, $5, cards, $6
+			FROM drawn
+			RETURNING id, created_at
+		), draw AS (
+			INSERT INTO deck_draws (deck_id, cards, version)
+			SELECT id, cards, version
+			FROM drawn
+			RETURNING id
+		)
+		SELECT drawn.cards, drawn.version, event.id, event.created_at, draw.id
+		FROM drawn, event, draw`
+
+	event.Action = EventDraw
+
+	args := []any{draw.DeckID, next, count, event.Action, event.Pile, event.RequestID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		pq.Array(&draw.Cards),
+		&draw.Version,
+		&event.ID,
+		&event.CreatedAt,
+		&draw.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cursors only ever point at rows that exist, so this would mean the
+	// deck is corrupt. Failing keeps the cards from being lost with the draw.
+	if len(draw.Cards) != count {
+		return nil, fmt.Errorf("deck %s: read %d cards at position %d, expected %d", draw.DeckID, len(draw.Cards), next, count)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	event.DeckID = draw.DeckID
+	event.Cards = draw.Cards
+
+	return &draw, nil
+}
+
+// Undo reverses the latest count draws from the top of the deck, provided no
+// other change was made to the deck since, and records it as an undo event.
+// The deck must also still be at version, the one the client saw, or it
+// returns ErrEditConflict; otherwise a draw made by someone else in the
+// meantime would be undone.
+func (d DeckModel) Undo(ctx context.Context, id string, count, version int, event *Event) (*Deck, error) {
+	ctx, cancel := queryContext(ctx, d.Timeout)
+	defer cancel()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockDeck(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, shuffled, shuffle_algorithm, commitment, closed, deck_count, ` + remainingCards + `, version
+		FROM decks
+		WHERE id::text = $1`
+
+	var deck Deck
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&deck.ID,
+		&deck.Shuffled,
+		&deck.ShuffleAlgorithm,
+		&deck.Commitment,
+		&deck.Closed,
+		&deck.DeckCount,
+		pq.Array(&deck.Cards),
+		&deck.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if deck.Closed {
+		return nil, ErrDeckClosed
+	}
+
+	if version != deck.Version {
+		return nil, ErrEditConflict
+	}
+
+	draws, err := latestDraws(ctx, tx, deck.ID, count+1)
+	if err != nil {
+		return nil, err
+	}
+
+	undone := draws
+	if len(undone) > count {
+		undone = undone[:count]
+	}
+
+	if len(undone) < count {
+		return nil, ErrNothingToUndo
+	}
+
+	deck.Cards, err = undoDraws(deck.Cards, deck.Version, undone)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE decks
+		SET updated_at = NOW(), version = version + 1
+		WHERE id::text = $1 AND version = $2
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, deck.ID, deck.Version).Scan(&deck.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = saveDeckCards(ctx, tx, deck.ID, deck.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(undone))
+	for i, draw := range undone {
+		ids[i] = draw.ID
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM deck_draws WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	// The deck is back in the state the draw before the undone ones left it
+	// in, so that draw can be undone next.
+	if len(draws) > count && draws[count].Version == undone[count-1].Version-1 {
+		_, err = tx.ExecContext(ctx, `UPDATE deck_draws SET version = $1 WHERE id = $2`, deck.Version, draws[count].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	restored := 0
+	for _, draw := range undone {
+		restored += len(draw.Cards)
+	}
+
+	event.DeckID = deck.ID
+	event.Action = EventUndo
+	event.Cards = deck.Cards[:restored]
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deck, tx.Commit()
+}
+
+// latestDraws returns up to limit draws of the deck, newest first.
+func latestDraws(ctx context.Context, tx *sql.Tx, deckID string, limit int) ([]*Draw, error) {
+	query := `
+		SELECT id, deck_id, cards, version
+		FROM deck_draws
+		WHERE deck_id::text = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+	rows, err := tx.QueryContext(ctx, query, deckID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var draws []*Draw
+
+	for rows.Next() {
+		var draw Draw
+
+		err := rows.Scan(&draw.ID, &draw.DeckID, pq.Array(&draw.Cards), &draw.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		draws = append(draws, &draw)
+	}
+
+	return draws, rows.Err()
+}
+
+// insertDraw records a draw as part of tx so it can be undone later.
+func insertDraw(ctx context.Context, tx *sql.Tx, draw *Draw) error {
+	query := `
+		INSERT INTO deck_draws (deck_id, cards, version)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+	return tx.QueryRowContext(ctx, query, draw.DeckID, pq.Array(draw.Cards), draw.Version).Scan(&draw.ID)
+}
+
+// -------------------------------------------------
+
+func (m MockDeckModel) Draw(ctx context.Context, id string, count int, event *Event) (*Draw, error) {
+	deck, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDraw(deck.Closed, len(deck.Cards), count)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := Draw{
+		DeckID:  id,
+		Cards:   deck.Cards[:count],
+		Version: deck.Version + 1,
+	}
+
+	return &draw, nil
+}
+
+func (m MockDeckModel) Undo(ctx context.Context, id string, count, version int, event *Event) (*Deck, error) {
+	deck, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != deck.Version {
+		return nil, ErrEditConflict
+	}
+
+	if count > 1 {
+		return nil, ErrNothingToUndo
+	}
+
+	draws := []*Draw{{DeckID: id, Cards: MockDealtCards, Version: deck.Version}}
+
+	deck.Cards, err = undoDraws(deck.Cards, deck.Version, draws)
+	if err != nil {
+		return nil, err
+	}
+
+	return deck, nil
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestUndoDraws(t *testing.T) {
+	cards, err := ParseCards([]string{"AS", "2S", "3S", "4S", "5S"})
+	assert.NilError(t, err)
+
+	// Two draws took AS, 2S and then 3S, leaving the deck at version 3.
+	remaining := cards[3:]
+	draws := []*Draw{
+		{Cards: cards[2:3], Version: 3},
+		{Cards: cards[0:2], Version: 2},
+	}
+
+	t.Run("puts the latest draw back on top", func(t *testing.T) {
+		got, err := undoDraws(remaining, 3, draws[:1])
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got), "3S 4S 5S")
+	})
+
+	t.Run("puts several draws back in their original order", func(t *testing.T) {
+		got, err := undoDraws(remaining, 3, draws)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got), "AS 2S 3S 4S 5S")
+	})
+
+	t.Run("refuses when the deck changed after the draw", func(t *testing.T) {
+		_, err := undoDraws(remaining, 4, draws[:1])
+		assert.Equal(t, errors.Is(err, ErrNothingToUndo), true)
+	})
+
+	t.Run("refuses when another change happened between draws", func(t *testing.T) {
+		gapped := []*Draw{draws[0], {Cards: cards[0:2], Version: 1}}
+
+		_, err := undoDraws(remaining, 3, gapped)
+		assert.Equal(t, errors.Is(err, ErrNothingToUndo), true)
+	})
+
+	t.Run("refuses when there are no draws", func(t *testing.T) {
+		_, err := undoDraws(remaining, 3, nil)
+		assert.Equal(t, errors.Is(err, ErrNothingToUndo), true)
+	})
+}
+
+// BenchmarkDraw compares drawing a card by reading the deck, slicing its cards
+// in Go and writing them all back, as draws used to work, with the single
+// statement of Decks.Draw. PostgreSQL only runs when CARDS_TEST_DB_DSN is set.
+func BenchmarkDraw(b *testing.B) {
+	ctx := context.Background()
+
+	names := []string{"memory"}
+	backends := map[string]Models{
+		"memory": NewMemoryModels(NewMemoryStore()),
+	}
+
+	if dsn := os.Getenv("CARDS_TEST_DB_DSN"); dsn != "" {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		runScript(b, db, "test/setup.sql")
+		b.Cleanup(func() {
+			runScript(b, db, "test/teardown.sql")
+			db.Close()
+		})
+
+		names = append(names, "postgres")
+		backends["postgres"] = NewModels(db, DefaultQueryTimeout)
+	}
+
+	draws := []struct {
+		name string
+		draw func(m Models, id string) error
+	}{
+		{"read-modify-write", func(m Models, id string) error {
+			deck, err := m.Decks.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			drawn := deck.Cards[:1]
+			deck.Cards = deck.Cards[1:]
+
+			return m.Decks.Update(ctx, deck, &Event{Action: EventDraw, Cards: drawn})
+		}},
+		{"atomic", func(m Models, id string) error {
+			_, err := m.Decks.Draw(ctx, id, 1, &Event{})
+			return err
+		}},
+	}
+
+	for _, name := range names {
+		m := backends[name]
+
+		for _, d := range draws {
+			b.Run(name+"/"+d.name, func(b *testing.B) {
+				var deck *Deck
+
+				for i := 0; i < b.N; i++ {
+					if i%MaxCards == 0 {
+						b.StopTimer()
+						deck = &Deck{DeckCount: MaxDeckCount, Cards: GenerateShoe(MaxDeckCount)}
+						err := m.Decks.Insert(ctx, deck, &Event{})
+						if err != nil {
+							b.Fatal(err)
+						}
+						b.StartTimer()
+					}
+
+					err := d.draw(m, deck.ID)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkDeckCards measures the deck_cards layout: a draw moves the
+// next_position cursor and reads only the rows it passed, so drawing from the
+// largest shoe should cost about as much as drawing from a single deck. A
+// reshuffle appends the whole new order, so it grows with the cards left.
+// PostgreSQL only runs when CARDS_TEST_DB_DSN is set.
+func BenchmarkDeckCards(b *testing.B) {
+	ctx := context.Background()
+
+	names := []string{"memory"}
+	backends := map[string]Models{
+		"memory": NewMemoryModels(NewMemoryStore()),
+	}
+
+	if dsn := os.Getenv("CARDS_TEST_DB_DSN"); dsn != "" {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		runScript(b, db, "test/setup.sql")
+		b.Cleanup(func() {
+			runScript(b, db, "test/teardown.sql")
+			db.Close()
+		})
+
+		names = append(names, "postgres")
+		backends["postgres"] = NewModels(db, DefaultQueryTimeout)
+	}
+
+	shuffler, err := NewCryptoShuffler(DefaultShuffleAlgorithm)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ops := []struct {
+		name string
+		run  func(m Models, id string) error
+	}{
+		{"draw", func(m Models, id string) error {
+			_, err := m.Decks.Draw(ctx, id, 1, &Event{})
+			return err
+		}},
+		{"reshuffle", func(m Models, id string) error {
+			deck, err := m.Decks.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			event := &Event{}
+
+			err = ReshuffleDeck(deck, shuffler, event)
+			if err != nil {
+				return err
+			}
+
+			return m.Decks.Update(ctx, deck, event)
+		}},
+	}
+
+	for _, name := range names {
+		m := backends[name]
+
+		for _, deckCount := range []int{1, MaxDeckCount} {
+			for _, op := range ops {
+				b.Run(fmt.Sprintf("%s/%d-decks/%s", name, deckCount, op.name), func(b *testing.B) {
+					var deck *Deck
+
+					for i := 0; i < b.N; i++ {
+						// A draw empties the deck after deckCount*CardsPerDeck
+						// iterations; a reshuffle leaves it full.
+						if deck == nil || (op.name == "draw" && i%(deckCount*CardsPerDeck) == 0) {
+							b.StopTimer()
+							deck = &Deck{DeckCount: deckCount, Cards: GenerateShoe(deckCount)}
+							err := m.Decks.Insert(ctx, deck, &Event{})
+							if err != nil {
+								b.Fatal(err)
+							}
+							b.StartTimer()
+						}
+
+						err := op.run(m, deck.ID)
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+)
+
+const (
+	EventCreate  = "create"
+	EventDraw    = "draw"
+	EventShuffle = "shuffle"
+	EventReturn  = "return"
+	EventUndo    = "undo"
+	EventClose   = "close"
+	EventDelete  = "delete"
+	EventPurge   = "purge"
+
+	EventPileDraw = "pile_draw"
+	EventPileMove = "pile_move"
+)
+
+// Event records a change to a deck. Events are written in the same
+// transaction as the change itself.
+type Event struct {
+	ID        int64     `json:"id"`
+	DeckID    string    `json:"deck_id"`
+	Action    string    `json:"action"`
+	Pile      string    `json:"pile,omitempty"`
+	ToPile    string    `json:"to_pile,omitempty"`
+	Cards     []Card    `json:"cards,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// A shuffle event records how to replay the shuffle: the algorithm, the
+	// hex encoded seed and the HashCards of the order the cards were in.
+	ShuffleAlgorithm string `json:"shuffle_algorithm,omitempty"`
+	ShuffleSeed      string `json:"shuffle_seed,omitempty"`
+	InputHash        string `json:"input_hash,omitempty"`
+}
+
+// Cursor selects a page of events: the Limit events that come after the event
+// with ID After.
+type Cursor struct {
+	After int64
+	Limit int
+}
+
+type CursorMetadata struct {
+	NextCursor int64 `json:"next_cursor,omitempty"`
+	Limit      int   `json:"limit"`
+}
+
+func ValidateCursor(v *validator.Validator, c Cursor) {
+	v.Check(c.After >= 0, "cursor", "must not be negative")
+	v.Check(c.Limit > 0, "limit", "must be greater than zero")
+	v.Check(c.Limit <= 100, "limit", "must be a maximum of 100")
+}
+
+// calculateCursorMetadata returns the cursor of the next page, which is only
+// set when the page is full and more events may follow.
+func calculateCursorMetadata(events []*Event, limit int) CursorMetadata {
+	metadata := CursorMetadata{Limit: limit}
+
+	if len(events) == limit {
+		metadata.NextCursor = events[len(events)-1].ID
+	}
+
+	return metadata
+}
+
+// -------------------------------------------------
+
+type EventModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (e EventModel) GetAllForDeck(ctx context.Context, deckID string, cursor Cursor) ([]*Event, CursorMetadata, error) {
+	ctx, cancel := queryContext(ctx, e.Timeout)
+	defer cancel()
+
+	// Create events written before they stopped listing the cards would give
+	// away the order of a shuffled deck, so their cards are left out.
+	query := `
+		SELECT id, deck_id, action, pile, to_pile, CASE WHEN action = 'create' THEN '{}' ELSE cards END, request_id, created_at,
+			shuffle_algorithm, shuffle_seed, input_hash
+		FROM deck_events
+		WHERE deck_id::text = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`
+
+	rows, err := e.DB.QueryContext(ctx, query, deckID, cursor.After, cursor.Limit)
+	if err != nil {
+		return nil, CursorMetadata{}, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		err := rows.Scan(
+			&event.ID,
+			&event.DeckID,
+			&event.Action,
+			&event.Pile,
+			&event.ToPile,
+			pq.Array(&event.Cards),
+			&event.RequestID,
+			&event.CreatedAt,
+			&event.ShuffleAlgorithm,
+			&event.ShuffleSeed,
+			&event.InputHash,
+		)
+		if err != nil {
+			return nil, CursorMetadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, CursorMetadata{}, err
+	}
+
+	return events, calculateCursorMetadata(events, cursor.Limit), nil
+}
+
+// insertEvent writes event as part of tx.
+func insertEvent(ctx context.Context, tx *sql.Tx, event *Event) error {
+	query := `
+		INSERT INTO deck_events (deck_id, action, pile, to_pile, cards, request_id, shuffle_algorithm, shuffle_seed, input_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`
+
+	if event.Cards == nil {
+		event.Cards = []Card{}
+	}
+
+	args := []any{
+		event.DeckID,
+		event.Action,
+		event.Pile,
+		event.ToPile,
+		pq.Array(event.Cards),
+		event.RequestID,
+		event.ShuffleAlgorithm,
+		event.ShuffleSeed,
+		event.InputHash,
+	}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// -------------------------------------------------
+
+type MockEventModel struct{}
+
+func (m MockEventModel) GetAllForDeck(ctx context.Context, deckID string, cursor Cursor) ([]*Event, CursorMetadata, error) {
+	events := []*Event{}
+
+	if deckID == MockID && cursor.After == 0 {
+		events = append(events, &Event{
+			ID:     1,
+			DeckID: deckID,
+			Action: EventCreate,
+		})
+	}
+
+	return events, calculateCursorMetadata(events, cursor.Limit), nil
+}
@@ -0,0 +1,22 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestCalculateCursorMetadata(t *testing.T) {
+	events := []*Event{{ID: 3}, {ID: 7}}
+
+	t.Run("Points at the last event of a full page", func(t *testing.T) {
+		metadata := calculateCursorMetadata(events, 2)
+		assert.Equal(t, metadata.NextCursor, int64(7))
+		assert.Equal(t, metadata.Limit, 2)
+	})
+
+	t.Run("Has no next cursor for the last page", func(t *testing.T) {
+		metadata := calculateCursorMetadata(events, 5)
+		assert.Equal(t, metadata.NextCursor, int64(0))
+	})
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// SecretSize is the size in bytes of the server secret a commitment is made
+// with.
+const SecretSize = 32
+
+// Commit returns the commitment to an order of cards: the hex encoded SHA-256
+// of the hex encoded secret, a colon and the card codes joined by commas, e.g.
+// sha256("5f1c...:AS,10H,3D"). It can be recomputed by anyone once the secret
+// and the order are revealed.
+func Commit(secret []byte, cards []Card) string {
+	codes := make([]string, len(cards))
+	for i, card := range cards {
+		codes[i] = card.Code()
+	}
+
+	message := hex.EncodeToString(secret) + ":" + strings.Join(codes, ",")
+	sum := sha256.Sum256([]byte(message))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// HashCards returns the hex encoded SHA-256 of the card codes joined by
+// commas, e.g. sha256("AS,10H,3D"). Shuffle events record it for the order the
+// cards were in before the shuffle.
+func HashCards(cards []Card) string {
+	codes := make([]string, len(cards))
+	for i, card := range cards {
+		codes[i] = card.Code()
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(codes, ",")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyCommitment reports whether commitment was made to cards with the
+// given hex encoded secret.
+func VerifyCommitment(commitment, secret string, cards []Card) bool {
+	secretBytes, err := hex.DecodeString(secret)
+	if err != nil {
+		return false
+	}
+
+	want := Commit(secretBytes, cards)
+
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(commitment)), []byte(want)) == 1
+}
+
+// CommitDeck commits to the current order of the deck's cards with a fresh
+// secret from crypto/rand.
+func CommitDeck(deck *Deck) error {
+	secret := make([]byte, SecretSize)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return err
+	}
+
+	deck.Secret = secret
+	deck.Commitment = Commit(secret, deck.Cards)
+
+	return nil
+}
+
+// Revealable reports whether the secret and original order of the deck may be
+// published: once it was closed, or once every card has left both the deck
+// and its piles. Cards still held in piles are hands in play, which the
+// original order would give away.
+func (deck *Deck) Revealable() bool {
+	if deck.Closed {
+		return true
+	}
+
+	for _, size := range deck.Piles {
+		if size > 0 {
+			return false
+		}
+	}
+
+	return len(deck.Cards) == 0
+}
+
+type Reveal struct {
+	DeckID           string `json:"deck_id"`
+	Commitment       string `json:"commitment"`
+	Secret           string `json:"secret"`
+	ShuffleAlgorithm string `json:"shuffle_algorithm"`
+	ShuffleSeed      string `json:"shuffle_seed"`
+	Cards            []Card `json:"cards"`
+}
+
+func NewReveal(deck *Deck) *Reveal {
+	return &Reveal{
+		DeckID:           deck.ID,
+		Commitment:       deck.Commitment,
+		Secret:           hex.EncodeToString(deck.Secret),
+		ShuffleAlgorithm: deck.ShuffleAlgorithm,
+		ShuffleSeed:      hex.EncodeToString(deck.ShuffleSeed),
+		Cards:            deck.InitialCards,
+	}
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestCommitment(t *testing.T) {
+	deck := Deck{Cards: GenerateAllCards()}
+
+	shuffler, err := NewCryptoShuffler(DefaultShuffleAlgorithm)
+	assert.NilError(t, err)
+
+	err = ShuffleDeck(&deck, shuffler)
+	assert.NilError(t, err)
+
+	err = CommitDeck(&deck)
+	assert.NilError(t, err)
+
+	secret := hex.EncodeToString(deck.Secret)
+
+	t.Run("Verifies against the committed order and secret", func(t *testing.T) {
+		assert.Equal(t, len(deck.Commitment), 64)
+		assert.Equal(t, VerifyCommitment(deck.Commitment, secret, deck.Cards), true)
+	})
+
+	t.Run("Fails for a different order", func(t *testing.T) {
+		cards := append([]Card{}, deck.Cards...)
+		cards[0], cards[1] = cards[1], cards[0]
+
+		assert.Equal(t, VerifyCommitment(deck.Commitment, secret, cards), false)
+	})
+
+	t.Run("Fails for a different or malformed secret", func(t *testing.T) {
+		other := make([]byte, SecretSize)
+
+		assert.Equal(t, VerifyCommitment(deck.Commitment, hex.EncodeToString(other), deck.Cards), false)
+		assert.Equal(t, VerifyCommitment(deck.Commitment, "not hex", deck.Cards), false)
+	})
+
+	t.Run("Matches the documented message format", func(t *testing.T) {
+		cards, err := ParseCards([]string{"AS", "10H"})
+		assert.NilError(t, err)
+
+		// sha256("0102:AS,10H")
+		want := "7407577ddd51b9bbfa7f1c88640dafe4607a3d8a2fd9a7931a75a2aa2885de50"
+
+		assert.Equal(t, Commit([]byte{1, 2}, cards), want)
+	})
+}
+
+func TestRevealable(t *testing.T) {
+	tests := []struct {
+		name string
+		deck Deck
+		want bool
+	}{
+		{"Deck in play", Deck{Cards: MockCards}, false},
+		{"Exhausted deck", Deck{}, true},
+		{"Exhausted deck with empty piles", Deck{Piles: map[string]int{"hand": 0}}, true},
+		{"Exhausted deck with a dealt pile", Deck{Piles: map[string]int{"hand": 0, "table": 2}}, false},
+		{"Closed deck with a dealt pile", Deck{Closed: true, Cards: MockCards, Piles: map[string]int{"table": 2}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.deck.Revealable(), tt.want)
+		})
+	}
+}
+
+func TestHashCards(t *testing.T) {
+	cards, err := ParseCards([]string{"AS", "10H"})
+	assert.NilError(t, err)
+
+	// sha256("AS,10H")
+	want := "d0ae461ca8662646e3740a3d6aca89d76a6e0644112ee42cb42c3fed3341bf30"
+
+	assert.Equal(t, HashCards(cards), want)
+}
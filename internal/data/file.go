@@ -0,0 +1,185 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// snapshotEvery is how many records a file store appends before it compacts
+// its file into a single snapshot.
+const snapshotEvery = 1000
+
+// fileLog is the file of a file store: one JSON storeRecord per line, starting
+// with a snapshot once the file has been compacted. Replaying the records in
+// order rebuilds the store.
+type fileLog struct {
+	path    string
+	file    *os.File
+	records int
+	// err is the last compaction error, reported by the readiness probe.
+	err error
+}
+
+// OpenFileStore opens the store kept in the file at path, creating the file
+// if needed. A record cut short by a crash while it was being written is
+// dropped; any other damage to the file is an error.
+func OpenFileStore(path string) (*MemoryStore, error) {
+	s := NewMemoryStore()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	records, size, err := s.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = f.Truncate(size)
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.file = &fileLog{path: path, file: f, records: records}
+
+	if records >= snapshotEvery {
+		err = s.file.snapshot(s)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// replay applies the records in r and returns how many there were and the
+// size of the complete ones.
+func (s *MemoryStore) replay(r io.Reader) (int, int64, error) {
+	reader := bufio.NewReader(r)
+
+	var records int
+	var size int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without a newline is a write that never finished.
+			return records, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var rec storeRecord
+
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			return 0, 0, fmt.Errorf("record %d: %w", records+1, err)
+		}
+
+		s.apply(&rec)
+
+		records++
+		size += int64(len(line))
+	}
+}
+
+// append writes rec to the end of the file and waits for it to reach the
+// disk.
+func (l *fileLog) append(rec *storeRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	l.records++
+
+	return nil
+}
+
+// snapshot replaces the file with a single record holding the whole store.
+// The snapshot is written to a temporary file first and renamed over the old
+// file, so a crash leaves either the old file or the new one. The caller must
+// hold the store's lock.
+func (l *fileLog) snapshot(s *MemoryStore) error {
+	rec := storeRecord{
+		Snapshot:    true,
+		NextEventID: s.nextEventID,
+		Decks:       make([]*storedDeck, 0, len(s.decks)),
+	}
+
+	for _, deck := range s.decks {
+		rec.Decks = append(rec.Decks, deck)
+	}
+
+	for _, events := range s.events {
+		rec.Events = append(rec.Events, events...)
+	}
+
+	sort.Slice(rec.Decks, func(i, j int) bool { return rec.Decks[i].ID < rec.Decks[j].ID })
+	sort.Slice(rec.Events, func(i, j int) bool { return rec.Events[i].ID < rec.Events[j].ID })
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+
+	err = os.WriteFile(tmp, append(line, '\n'), 0o600)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(tmp, os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err == nil {
+		_, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = os.Rename(tmp, l.path)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file.Close()
+	l.file = f
+	l.records = 1
+
+	return nil
+}
+
+func (l *fileLog) close() error {
+	return l.file.Close()
+}
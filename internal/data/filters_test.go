@@ -0,0 +1,50 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/internal/validator"
+)
+
+func TestCalculateMetadata(t *testing.T) {
+	t.Run("Rounds the last page up", func(t *testing.T) {
+		metadata := calculateMetadata(41, 2, 20)
+		assert.Equal(t, metadata, Metadata{CurrentPage: 2, PageSize: 20, FirstPage: 1, LastPage: 3, TotalRecords: 41})
+	})
+
+	t.Run("Is empty when nothing matched", func(t *testing.T) {
+		assert.Equal(t, calculateMetadata(0, 1, 20), Metadata{})
+	})
+}
+
+func TestDeckFilters(t *testing.T) {
+	valid := DeckFilters{
+		MaxRemaining: MaxCards,
+		Filters:      Filters{Page: 1, PageSize: 20, Sort: "-remaining", SortSafelist: DeckSortSafelist},
+	}
+
+	t.Run("Sorts by the column of a safelisted value", func(t *testing.T) {
+		assert.Equal(t, valid.sortColumn(), "remaining")
+		assert.Equal(t, valid.sortDirection(), "DESC")
+		assert.Equal(t, valid.offset(), 0)
+	})
+
+	t.Run("Rejects values outside the safelist and invalid ranges", func(t *testing.T) {
+		tests := []func(f *DeckFilters){
+			func(f *DeckFilters) { f.Sort = "secret" },
+			func(f *DeckFilters) { f.PageSize = 101 },
+			func(f *DeckFilters) { f.MinRemaining = 10; f.MaxRemaining = 5 },
+			func(f *DeckFilters) { f.Labels = Labels{"Not A Key": "x"} },
+		}
+
+		for _, tt := range tests {
+			f := valid
+			tt(&f)
+
+			v := validator.New()
+			ValidateDeckFilters(v, f)
+			assert.Equal(t, v.Valid(), false)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SchemaVersion is the migration the code expects the database to be at. The
+// code also runs against a database at MinSchemaVersion or later, which lets
+// migrations that only drop what the code no longer uses, such as the cards
+// array of decks, be applied after the new code is rolled out.
+const (
+	SchemaVersion    = 22
+	MinSchemaVersion = 22
+)
+
+// SchemaSupported reports whether the code runs against a database at version.
+// A dirty database is never supported, whatever its version.
+func SchemaSupported(version int, dirty bool) bool {
+	return !dirty && version >= MinSchemaVersion && version <= SchemaVersion
+}
+
+// HealthModel reports on the database for the readiness probe.
+type HealthModel struct {
+	DB *sql.DB
+}
+
+func (m HealthModel) Ping(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
+// MigrationVersion returns the version recorded in schema_migrations and
+// whether the last migration failed half way. A database that was never
+// migrated is at version 0.
+func (m HealthModel) MigrationVersion(ctx context.Context) (int, bool, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	var version int
+	var dirty bool
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+func (m HealthModel) Stats() sql.DBStats {
+	return m.DB.Stats()
+}
+
+// -------------------------------------------------
+
+type MockHealthModel struct{}
+
+func (m MockHealthModel) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m MockHealthModel) MigrationVersion(ctx context.Context) (int, bool, error) {
+	return SchemaVersion, false, nil
+}
+
+func (m MockHealthModel) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/scchi/cards/internal/validator"
+)
+
+const (
+	MaxLabels          = 16
+	MaxLabelValueBytes = 64
+)
+
+var LabelKeyRX = regexp.MustCompile("^[a-z0-9][a-z0-9_.-]{0,31}$")
+
+// Labels are free-form key/value metadata attached to a deck, e.g.
+// {"table": "7", "game": "blackjack"}, which decks can be listed by.
+type Labels map[string]string
+
+func ValidateLabels(v *validator.Validator, labels Labels) {
+	v.Check(len(labels) <= MaxLabels, "labels", fmt.Sprintf("must not have more than %d labels", MaxLabels))
+
+	for key, value := range labels {
+		v.Check(validator.Matches(key, LabelKeyRX), "labels", "keys must be 1 to 32 lowercase letters, digits, dots, dashes or underscores")
+		v.Check(len(value) <= MaxLabelValueBytes, "labels", fmt.Sprintf("values must not be more than %d bytes long", MaxLabelValueBytes))
+	}
+}
+
+// Value stores the labels as a JSON object.
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+
+	js, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(js), nil
+}
+
+// Scan reads labels back from a JSON object.
+func (l *Labels) Scan(src any) error {
+	var js []byte
+
+	switch v := src.(type) {
+	case string:
+		js = []byte(v)
+	case []byte:
+		js = v
+	default:
+		return fmt.Errorf("cannot scan %T into Labels", src)
+	}
+
+	return json.Unmarshal(js, l)
+}
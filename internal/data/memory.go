@@ -0,0 +1,984 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps decks together with their piles, undoable draws and
+// events in memory, for running the API without PostgreSQL. It is safe for
+// concurrent use: every operation holds the store's lock, which makes it as
+// atomic as the transaction it replaces. A store opened with OpenFileStore
+// also writes every change to a file before applying it.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	decks       map[string]*storedDeck
+	events      map[string][]*Event
+	nextEventID int64
+	file        *fileLog
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		decks:  make(map[string]*storedDeck),
+		events: make(map[string][]*Event),
+	}
+}
+
+// NewMemoryModels returns models backed by the store. Accounts need
+// PostgreSQL, so the user, token, permission and API key models are left nil.
+func NewMemoryModels(store *MemoryStore) Models {
+	return Models{
+		Decks:  MemoryDeckModel{Store: store},
+		Piles:  MemoryPileModel{Store: store},
+		Events: MemoryEventModel{Store: store},
+		Health: MemoryHealthModel{Store: store},
+	}
+}
+
+// Close compacts and closes the file of a file store. It does nothing for a
+// store that only lives in memory.
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.snapshot(s)
+	if err != nil {
+		s.file.close()
+		return err
+	}
+
+	return s.file.close()
+}
+
+// storedDeck is the full state of a deck, including what the decks, piles and
+// deck_draws tables keep in PostgreSQL.
+type storedDeck struct {
+	ID               string                 `json:"id"`
+	OwnerID          *int64                 `json:"owner_id,omitempty"`
+	Shuffled         bool                   `json:"shuffled"`
+	ShuffleAlgorithm string                 `json:"shuffle_algorithm"`
+	ShuffleSeed      []byte                 `json:"shuffle_seed,omitempty"`
+	Commitment       string                 `json:"commitment"`
+	Secret           []byte                 `json:"secret,omitempty"`
+	Closed           bool                   `json:"closed"`
+	DeckCount        int                    `json:"deck_count"`
+	Cards            cardCodes              `json:"cards"`
+	InitialCards     cardCodes              `json:"initial_cards"`
+	Labels           Labels                 `json:"labels,omitempty"`
+	ExpiresAt        *time.Time             `json:"expires_at,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+	Version          int                    `json:"version"`
+	ShareVersion     int                    `json:"share_version"`
+	Piles            map[string]*storedPile `json:"piles,omitempty"`
+	// Draws are the draws that can still be undone, oldest first.
+	Draws []*storedDraw `json:"draws,omitempty"`
+}
+
+type storedPile struct {
+	Cards     cardCodes `json:"cards"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+}
+
+type storedDraw struct {
+	Cards   cardCodes `json:"cards"`
+	Version int       `json:"version"`
+}
+
+// cardCodes writes cards as their codes, which keeps the file of a file store
+// small. Card.UnmarshalJSON reads codes back.
+type cardCodes []Card
+
+func (c cardCodes) MarshalJSON() ([]byte, error) {
+	codes := make([]string, len(c))
+	for i, card := range c {
+		codes[i] = card.Code()
+	}
+
+	return json.Marshal(codes)
+}
+
+func (d *storedDeck) live(now time.Time) bool {
+	return d.ExpiresAt == nil || d.ExpiresAt.After(now)
+}
+
+func (d *storedDeck) clone() *storedDeck {
+	c := *d
+	c.Cards = append(cardCodes{}, d.Cards...)
+	c.InitialCards = append(cardCodes{}, d.InitialCards...)
+
+	c.Piles = make(map[string]*storedPile, len(d.Piles))
+	for name, pile := range d.Piles {
+		p := *pile
+		p.Cards = append(cardCodes{}, pile.Cards...)
+		c.Piles[name] = &p
+	}
+
+	c.Draws = make([]*storedDraw, len(d.Draws))
+	for i, draw := range d.Draws {
+		dr := *draw
+		c.Draws[i] = &dr
+	}
+
+	return &c
+}
+
+// deck returns the deck as Get returns it.
+func (d *storedDeck) deck() *Deck {
+	deck := &Deck{
+		ID:               d.ID,
+		OwnerID:          d.OwnerID,
+		Shuffled:         d.Shuffled,
+		ShuffleAlgorithm: d.ShuffleAlgorithm,
+		ShuffleSeed:      d.ShuffleSeed,
+		Commitment:       d.Commitment,
+		Secret:           d.Secret,
+		Closed:           d.Closed,
+		DeckCount:        d.DeckCount,
+		Cards:            append([]Card{}, d.Cards...),
+		InitialCards:     append([]Card{}, d.InitialCards...),
+		Piles:            make(map[string]int, len(d.Piles)),
+		Labels:           d.Labels,
+		ExpiresAt:        d.ExpiresAt,
+		CreatedAt:        d.CreatedAt,
+		Version:          d.Version,
+		ShareVersion:     d.ShareVersion,
+	}
+
+	for name, pile := range d.Piles {
+		deck.Piles[name] = len(pile.Cards)
+	}
+
+	return deck
+}
+
+// pile returns the named pile, or a new empty one with a zero Version.
+func (d *storedDeck) pile(name string, now time.Time) *storedPile {
+	pile, ok := d.Piles[name]
+	if !ok {
+		pile = &storedPile{CreatedAt: now}
+		d.Piles[name] = pile
+	}
+
+	return pile
+}
+
+// storeRecord is a change to a store: decks to save, decks to delete, decks
+// to purge along with their events, and events to add. Events are added last,
+// so a record can purge a deck and leave a purge event for it. A snapshot
+// replaces everything in the store.
+type storeRecord struct {
+	Snapshot    bool          `json:"snapshot,omitempty"`
+	NextEventID int64         `json:"next_event_id,omitempty"`
+	Decks       []*storedDeck `json:"decks,omitempty"`
+	Deleted     []string      `json:"deleted,omitempty"`
+	Purged      []string      `json:"purged,omitempty"`
+	Events      []*Event      `json:"events,omitempty"`
+}
+
+// commit writes rec to the file of a file store, then applies it. The caller
+// must hold the write lock and not touch rec afterwards.
+func (s *MemoryStore) commit(rec *storeRecord) error {
+	// Callers keep their events, so the store needs its own copies.
+	for i, event := range rec.Events {
+		e := *event
+		e.Cards = append([]Card{}, event.Cards...)
+		rec.Events[i] = &e
+	}
+
+	if s.file != nil {
+		err := s.file.append(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.apply(rec)
+
+	if s.file != nil && s.file.records >= snapshotEvery {
+		// The change is safely in the log already, so a failed snapshot only
+		// shows up in the readiness probe.
+		s.file.err = s.file.snapshot(s)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) apply(rec *storeRecord) {
+	if rec.Snapshot {
+		s.decks = make(map[string]*storedDeck)
+		s.events = make(map[string][]*Event)
+	}
+
+	if rec.NextEventID > s.nextEventID {
+		s.nextEventID = rec.NextEventID
+	}
+
+	for _, deck := range rec.Decks {
+		s.decks[deck.ID] = deck
+	}
+
+	for _, id := range rec.Deleted {
+		delete(s.decks, id)
+	}
+
+	for _, id := range rec.Purged {
+		delete(s.decks, id)
+		delete(s.events, id)
+	}
+
+	for _, event := range rec.Events {
+		s.events[event.DeckID] = append(s.events[event.DeckID], event)
+
+		if event.ID > s.nextEventID {
+			s.nextEventID = event.ID
+		}
+	}
+}
+
+// liveDeck returns a copy of the deck with the given id, provided it hasn't
+// expired.
+func (s *MemoryStore) liveDeck(id string, now time.Time) (*storedDeck, error) {
+	deck, ok := s.decks[id]
+	if !ok || !deck.live(now) {
+		return nil, ErrRecordNotFound
+	}
+
+	return deck.clone(), nil
+}
+
+// stampEvent gives event the next ID. The caller must hold the write lock.
+func (s *MemoryStore) stampEvent(event *Event, now time.Time) {
+	if event.Cards == nil {
+		event.Cards = []Card{}
+	}
+
+	event.ID = s.nextEventID + 1
+	event.CreatedAt = now
+}
+
+// newUUID returns a random (version 4) UUID, like uuid_generate_v4().
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// -------------------------------------------------
+
+type MemoryDeckModel struct {
+	Store *MemoryStore
+}
+
+func (m MemoryDeckModel) Insert(ctx context.Context, deck *Deck, event *Event) error {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	deck.ID = id
+	deck.InitialCards = deck.Cards
+	deck.CreatedAt = now.Truncate(time.Second)
+	deck.Version = 1
+	deck.ShareVersion = 1
+
+	stored := &storedDeck{
+		ID:               deck.ID,
+		OwnerID:          deck.OwnerID,
+		Shuffled:         deck.Shuffled,
+		ShuffleAlgorithm: deck.ShuffleAlgorithm,
+		ShuffleSeed:      deck.ShuffleSeed,
+		Commitment:       deck.Commitment,
+		Secret:           deck.Secret,
+		DeckCount:        deck.DeckCount,
+		Cards:            append(cardCodes{}, deck.Cards...),
+		InitialCards:     append(cardCodes{}, deck.Cards...),
+		Labels:           deck.Labels,
+		ExpiresAt:        deck.ExpiresAt,
+		CreatedAt:        deck.CreatedAt,
+		UpdatedAt:        now,
+		Version:          deck.Version,
+		ShareVersion:     deck.ShareVersion,
+		Piles:            make(map[string]*storedPile),
+	}
+
+	event.DeckID = deck.ID
+	event.Action = EventCreate
+	s.stampEvent(event, now)
+
+	return s.commit(&storeRecord{Decks: []*storedDeck{stored}, Events: []*Event{event}})
+}
+
+func (m MemoryDeckModel) Get(ctx context.Context, id string) (*Deck, error) {
+	s := m.Store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deck, err := s.liveDeck(id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return deck.deck(), nil
+}
+
+// GetAll returns a page of the decks matching filters, without their cards.
+func (m MemoryDeckModel) GetAll(ctx context.Context, filters DeckFilters) ([]*Deck, Metadata, error) {
+	s := m.Store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	decks := []*Deck{}
+
+	for _, stored := range s.decks {
+		if !stored.live(now) || !matchesDeckFilters(stored, filters) {
+			continue
+		}
+
+		deck := stored.deck()
+		deck.Remaining = len(deck.Cards)
+		deck.Cards = nil
+		deck.InitialCards = nil
+		deck.Piles = nil
+		deck.ShuffleSeed = nil
+		deck.Secret = nil
+
+		decks = append(decks, deck)
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(decks, func(i, j int) bool {
+		a, b := decks[i], decks[j]
+
+		var cmp int
+		switch column {
+		case "remaining":
+			cmp = a.Remaining - b.Remaining
+		case "deck_count":
+			cmp = a.DeckCount - b.DeckCount
+		default:
+			switch {
+			case a.CreatedAt.Before(b.CreatedAt):
+				cmp = -1
+			case a.CreatedAt.After(b.CreatedAt):
+				cmp = 1
+			}
+		}
+
+		if desc {
+			cmp = -cmp
+		}
+
+		if cmp == 0 {
+			return a.ID < b.ID
+		}
+
+		return cmp < 0
+	})
+
+	totalRecords := len(decks)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return decks[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func matchesDeckFilters(deck *storedDeck, f DeckFilters) bool {
+	if f.Shuffled != nil && deck.Shuffled != *f.Shuffled {
+		return false
+	}
+
+	if len(deck.Cards) < f.MinRemaining || len(deck.Cards) > f.MaxRemaining {
+		return false
+	}
+
+	if !f.CreatedAfter.IsZero() && deck.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+
+	if !f.CreatedBefore.IsZero() && !deck.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+
+	for key, value := range f.Labels {
+		if v, ok := deck.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	switch {
+	case f.OwnerID == nil:
+		return deck.OwnerID == nil
+	default:
+		return deck.OwnerID != nil && *deck.OwnerID == *f.OwnerID
+	}
+}
+
+// Update saves the cards, shuffle state and closed flag of the deck and
+// records event, provided nobody else has changed the deck since it was read.
+// Otherwise it returns ErrEditConflict. A draw event is also kept as a draw so
+// that it can be undone.
+func (m MemoryDeckModel) Update(ctx context.Context, deck *Deck, event *Event) error {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.decks[deck.ID]
+	if !ok || current.Version != deck.Version {
+		return ErrEditConflict
+	}
+
+	now := time.Now()
+
+	next := current.clone()
+	next.Cards = append(cardCodes{}, deck.Cards...)
+	next.Shuffled = deck.Shuffled
+	next.ShuffleAlgorithm = deck.ShuffleAlgorithm
+	next.ShuffleSeed = deck.ShuffleSeed
+	next.Closed = deck.Closed
+	next.UpdatedAt = now
+	next.Version++
+
+	event.DeckID = deck.ID
+	s.stampEvent(event, now)
+
+	if event.Action == EventDraw {
+		next.Draws = append(next.Draws, &storedDraw{Cards: append(cardCodes{}, event.Cards...), Version: next.Version})
+	}
+
+	err := s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return err
+	}
+
+	deck.Version = next.Version
+
+	return nil
+}
+
+// Draw takes count cards off the top of the deck and records them as a draw
+// event and as a draw that can be undone.
+func (m MemoryDeckModel) Draw(ctx context.Context, id string, count int, event *Event) (*Draw, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := s.liveDeck(id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDraw(next.Closed, len(next.Cards), count)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := Draw{
+		DeckID: id,
+		Cards:  append([]Card{}, next.Cards[:count]...),
+	}
+
+	next.Cards = append(cardCodes{}, next.Cards[count:]...)
+	next.UpdatedAt = now
+	next.Version++
+	next.Draws = append(next.Draws, &storedDraw{Cards: append(cardCodes{}, draw.Cards...), Version: next.Version})
+
+	event.DeckID = id
+	event.Action = EventDraw
+	event.Cards = draw.Cards
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	draw.Version = next.Version
+
+	return &draw, nil
+}
+
+// Return puts dealt cards back into the deck at position and records them as
+// a return event. Cards held in piles are not dealt, so they can't be
+// returned this way. A committed deck that may already have been revealed
+// can't take cards back either, so it returns ErrDeckRevealed.
+func (m MemoryDeckModel) Return(ctx context.Context, id string, cards []Card, position string, event *Event) (*Deck, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := s.liveDeck(id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if next.Closed {
+		return nil, ErrDeckClosed
+	}
+
+	if next.Commitment != "" && next.deck().Revealable() {
+		return nil, ErrDeckRevealed
+	}
+
+	inPlay := [][]Card{next.Cards}
+	for _, pile := range next.Piles {
+		inPlay = append(inPlay, pile.Cards)
+	}
+
+	outstanding := outstandingCards(next.InitialCards, inPlay...)
+	if cards == nil {
+		cards = outstanding
+	}
+
+	src, err := newCryptoSource()
+	if err != nil {
+		return nil, err
+	}
+
+	returned, err := returnCards(next.Cards, outstanding, cards, position, src)
+	if err != nil {
+		return nil, err
+	}
+
+	next.Cards = returned
+	next.UpdatedAt = now
+	next.Version++
+
+	event.DeckID = id
+	event.Action = EventReturn
+	event.Cards = cards
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	return next.deck(), nil
+}
+
+// Undo reverses the latest count draws from the top of the deck, provided no
+// other change was made to the deck since, and records it as an undo event.
+func (m MemoryDeckModel) Undo(ctx context.Context, id string, count, version int, event *Event) (*Deck, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := s.liveDeck(id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if next.Closed {
+		return nil, ErrDeckClosed
+	}
+
+	if version != next.Version {
+		return nil, ErrEditConflict
+	}
+
+	// Up to count+1 draws, newest first.
+	var draws []*Draw
+	for i := len(next.Draws) - 1; i >= 0 && len(draws) <= count; i-- {
+		draws = append(draws, &Draw{DeckID: id, Cards: next.Draws[i].Cards, Version: next.Draws[i].Version})
+	}
+
+	undone := draws
+	if len(undone) > count {
+		undone = undone[:count]
+	}
+
+	if len(undone) < count {
+		return nil, ErrNothingToUndo
+	}
+
+	cards, err := undoDraws(next.Cards, next.Version, undone)
+	if err != nil {
+		return nil, err
+	}
+
+	next.Cards = cards
+	next.UpdatedAt = now
+	next.Version++
+	next.Draws = next.Draws[:len(next.Draws)-count]
+
+	// The deck is back in the state the draw before the undone ones left it
+	// in, so that draw can be undone next.
+	if len(draws) > count && draws[count].Version == undone[count-1].Version-1 {
+		next.Draws[len(next.Draws)-1].Version = next.Version
+	}
+
+	restored := 0
+	for _, draw := range undone {
+		restored += len(draw.Cards)
+	}
+
+	event.DeckID = id
+	event.Action = EventUndo
+	event.Cards = append([]Card{}, next.Cards[:restored]...)
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	return next.deck(), nil
+}
+
+// Delete removes the deck together with its piles and draws. The events of
+// the deck are kept, ending with a delete event.
+func (m MemoryDeckModel) Delete(ctx context.Context, id string, event *Event) error {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	_, err := s.liveDeck(id, now)
+	if err != nil {
+		return err
+	}
+
+	event.DeckID = id
+	event.Action = EventDelete
+	s.stampEvent(event, now)
+
+	return s.commit(&storeRecord{Deleted: []string{id}, Events: []*Event{event}})
+}
+
+// RevokeShares invalidates every share token issued for the deck by bumping
+// its share version.
+func (m MemoryDeckModel) RevokeShares(ctx context.Context, id string) error {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := s.liveDeck(id, now)
+	if err != nil {
+		return err
+	}
+
+	next.ShareVersion++
+	next.UpdatedAt = now
+
+	return s.commit(&storeRecord{Decks: []*storedDeck{next}})
+}
+
+// Purge deletes up to limit decks that have expired or, when idle is more
+// than zero, haven't changed for longer than idle. Unlike Delete, it also
+// deletes the events of the decks, leaving a single purge event in their
+// place. It returns how many decks it deleted.
+func (m MemoryDeckModel) Purge(ctx context.Context, idle time.Duration, limit int) (int64, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var purged []string
+
+	for id, deck := range s.decks {
+		if len(purged) == limit {
+			break
+		}
+
+		if !deck.live(now) || (idle > 0 && deck.UpdatedAt.Before(now.Add(-idle))) {
+			purged = append(purged, id)
+		}
+	}
+
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	events := make([]*Event, len(purged))
+	for i, id := range purged {
+		events[i] = &Event{DeckID: id, Action: EventPurge, Cards: []Card{}, ID: s.nextEventID + int64(i) + 1, CreatedAt: now}
+	}
+
+	err := s.commit(&storeRecord{Purged: purged, Events: events})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(purged)), nil
+}
+
+// -------------------------------------------------
+
+type MemoryPileModel struct {
+	Store *MemoryStore
+}
+
+// lockedDeck returns a copy of the deck for a pile transfer. Closed decks
+// can't be dealt from, so it returns ErrDeckClosed for them. The caller must
+// hold the write lock.
+func (m MemoryPileModel) lockedDeck(deckID string, now time.Time) (*storedDeck, error) {
+	deck, err := m.Store.liveDeck(deckID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if deck.Closed {
+		return nil, ErrDeckClosed
+	}
+
+	return deck, nil
+}
+
+// Deal moves count cards from the top of the deck onto the top of the named
+// pile, creating the pile if needed, and records it as a draw event.
+func (m MemoryPileModel) Deal(ctx context.Context, deckID, name string, count int, event *Event) (*Pile, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := m.lockedDeck(deckID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	taken, rest, err := PileSelection{Count: count}.take(next.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	next.Cards = rest
+	next.UpdatedAt = now
+	next.Version++
+
+	pile := next.pile(name, now)
+	pile.Cards = append(append(cardCodes{}, taken...), pile.Cards...)
+	pile.Version++
+
+	event.DeckID = deckID
+	event.Action = EventDraw
+	event.Pile = name
+	event.Cards = taken
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	return storedPileToPile(deckID, name, pile), nil
+}
+
+func (m MemoryPileModel) Get(ctx context.Context, deckID, name string) (*Pile, error) {
+	s := m.Store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deck, err := s.liveDeck(deckID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	pile, ok := deck.Piles[name]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return storedPileToPile(deckID, name, pile), nil
+}
+
+// Draw removes the selected cards from the named pile and returns them, and
+// records it as a pile_draw event.
+func (m MemoryPileModel) Draw(ctx context.Context, deckID, name string, sel PileSelection, event *Event) ([]Card, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := m.lockedDeck(deckID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	pile, ok := next.Piles[name]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, rest, err := sel.take(pile.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	pile.Cards = rest
+	pile.Version++
+
+	event.DeckID = deckID
+	event.Action = EventPileDraw
+	event.Pile = name
+	event.Cards = taken
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	return taken, nil
+}
+
+// Move takes the selected cards from one pile and puts them on top of another,
+// creating the destination pile if needed, and records it as a pile_move
+// event.
+func (m MemoryPileModel) Move(ctx context.Context, deckID, from, to string, sel PileSelection, event *Event) (*Pile, error) {
+	s := m.Store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	next, err := m.lockedDeck(deckID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := next.Piles[from]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, rest, err := sel.take(source.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	source.Cards = rest
+	source.Version++
+
+	destination := next.pile(to, now)
+	destination.Cards = append(append(cardCodes{}, taken...), destination.Cards...)
+	destination.Version++
+
+	event.DeckID = deckID
+	event.Action = EventPileMove
+	event.Pile = from
+	event.ToPile = to
+	event.Cards = taken
+	s.stampEvent(event, now)
+
+	err = s.commit(&storeRecord{Decks: []*storedDeck{next}, Events: []*Event{event}})
+	if err != nil {
+		return nil, err
+	}
+
+	return storedPileToPile(deckID, to, destination), nil
+}
+
+func storedPileToPile(deckID, name string, pile *storedPile) *Pile {
+	return &Pile{
+		DeckID:    deckID,
+		Name:      name,
+		Cards:     append([]Card{}, pile.Cards...),
+		CreatedAt: pile.CreatedAt,
+		Version:   pile.Version,
+	}
+}
+
+// -------------------------------------------------
+
+type MemoryEventModel struct {
+	Store *MemoryStore
+}
+
+func (m MemoryEventModel) GetAllForDeck(ctx context.Context, deckID string, cursor Cursor) ([]*Event, CursorMetadata, error) {
+	s := m.Store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []*Event{}
+
+	for _, event := range s.events[deckID] {
+		if len(events) == cursor.Limit {
+			break
+		}
+
+		if event.ID > cursor.After {
+			e := *event
+			events = append(events, &e)
+		}
+	}
+
+	return events, calculateCursorMetadata(events, cursor.Limit), nil
+}
+
+// -------------------------------------------------
+
+// MemoryHealthModel reports a store as healthy unless writing its file
+// failed. A store has no migrations, so it is always at SchemaVersion.
+type MemoryHealthModel struct {
+	Store *MemoryStore
+}
+
+func (m MemoryHealthModel) Ping(ctx context.Context) error {
+	s := m.Store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.file != nil {
+		return s.file.err
+	}
+
+	return nil
+}
+
+func (m MemoryHealthModel) MigrationVersion(ctx context.Context) (int, bool, error) {
+	return SchemaVersion, false, nil
+}
+
+func (m MemoryHealthModel) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
@@ -1,31 +1,127 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+	ErrDeckClosed     = errors.New("deck closed")
+	ErrNothingToUndo  = errors.New("nothing to undo")
+	ErrDeckDealt      = errors.New("deck dealt")
+	ErrDeckRevealed   = errors.New("deck revealed")
 )
 
 type Models struct {
 	Decks interface {
-		Insert(deck *Deck) error
-		Get(id string) (*Deck, error)
-		Update(deck *Deck) error
+		Insert(ctx context.Context, deck *Deck, event *Event) error
+		Get(ctx context.Context, id string) (*Deck, error)
+		GetAll(ctx context.Context, filters DeckFilters) ([]*Deck, Metadata, error)
+		Update(ctx context.Context, deck *Deck, event *Event) error
+		Draw(ctx context.Context, id string, count int, event *Event) (*Draw, error)
+		Return(ctx context.Context, id string, cards []Card, position string, event *Event) (*Deck, error)
+		Undo(ctx context.Context, id string, count, version int, event *Event) (*Deck, error)
+		Delete(ctx context.Context, id string, event *Event) error
+		RevokeShares(ctx context.Context, id string) error
+		Purge(ctx context.Context, idle time.Duration, limit int) (int64, error)
+	}
+	Piles interface {
+		Deal(ctx context.Context, deckID, name string, count int, event *Event) (*Pile, error)
+		Get(ctx context.Context, deckID, name string) (*Pile, error)
+		Draw(ctx context.Context, deckID, name string, sel PileSelection, event *Event) ([]Card, error)
+		Move(ctx context.Context, deckID, from, to string, sel PileSelection, event *Event) (*Pile, error)
+	}
+	Events interface {
+		GetAllForDeck(ctx context.Context, deckID string, cursor Cursor) ([]*Event, CursorMetadata, error)
+	}
+	Users interface {
+		Insert(user *User) error
+		Register(user *User, activationTTL time.Duration, codes ...string) (*Token, error)
+		Get(id int64) (*User, error)
+		GetByEmail(email string) (*User, error)
+		Update(user *User) error
+		GetForToken(tokenScope, tokenPlaintext string) (*User, error)
+	}
+	Tokens interface {
+		New(userID int64, ttl time.Duration, scope string) (*Token, error)
+		Insert(token *Token) error
+		DeleteAllForUser(scope string, userID int64) error
+	}
+	Permissions interface {
+		GetAllForUser(userID int64) (Permissions, error)
+		AddForUser(userID int64, codes ...string) error
+	}
+	APIKeys interface {
+		Insert(key *APIKey) error
+		GetForKey(plaintext string) (*APIKey, error)
+		GetAllForUser(userID int64) ([]*APIKey, error)
+		Delete(id, userID int64) error
+	}
+	Health interface {
+		Ping(ctx context.Context) error
+		MigrationVersion(ctx context.Context) (int, bool, error)
+		Stats() sql.DBStats
 	}
 	Data map[string]string
 }
 
-func NewModels(db *sql.DB) Models {
+// DefaultQueryTimeout is how long a call to the deck models may spend in the
+// database unless told otherwise.
+const DefaultQueryTimeout = 3 * time.Second
+
+// NewModels returns models backed by db. Every call to the deck models is
+// given at most queryTimeout, on top of any deadline the caller's context
+// already has.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
 	return Models{
-		Decks: DeckModel{DB: db},
+		Decks:       DeckModel{DB: db, Timeout: queryTimeout},
+		Piles:       PileModel{DB: db, Timeout: queryTimeout},
+		Events:      EventModel{DB: db, Timeout: queryTimeout},
+		Users:       UserModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		APIKeys:     APIKeyModel{DB: db},
+		Health:      HealthModel{DB: db},
 	}
 }
 
+// queryContext derives the context a model call runs its queries with. A zero
+// timeout leaves ctx's own deadline, if any, in charge.
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// QueryCanceled reports whether err means a query was cut short because its
+// context was cancelled or ran out of time, whether database/sql noticed it
+// first or Postgres did.
+func QueryCanceled(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "57014"
+	}
+
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 func NewMockModels() Models {
 	return Models{
-		Decks: MockDeckModel{},
+		Decks:       MockDeckModel{},
+		Piles:       MockPileModel{},
+		Events:      MockEventModel{},
+		Users:       MockUserModel{},
+		Tokens:      MockTokenModel{},
+		Permissions: MockPermissionModel{},
+		APIKeys:     MockAPIKeyModel{},
+		Health:      MockHealthModel{},
 	}
 }
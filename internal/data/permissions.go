@@ -0,0 +1,95 @@
+package data
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+)
+
+const (
+	PermissionDecksRead  = "decks:read"
+	PermissionDecksDeal  = "decks:deal"
+	PermissionDecksAdmin = "decks:admin"
+)
+
+// AllPermissions are granted to new users. Requests without credentials get
+// them too, which keeps decks without an owner open to everyone; the owner
+// check on every deck route keeps them off owned decks, and API keys are how
+// access is narrowed down.
+var AllPermissions = Permissions{PermissionDecksRead, PermissionDecksDeal, PermissionDecksAdmin}
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidatePermissions(v *validator.Validator, permissions Permissions) {
+	v.Check(len(permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.PermittedValues(permissions, AllPermissions), "permissions", "must only contain decks:read, decks:deal or decks:admin")
+	v.Check(validator.Unique(permissions), "permissions", "must not contain duplicate values")
+}
+
+// -------------------------------------------------
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err := m.DB.Exec(query, userID, pq.Array(codes))
+	return err
+}
+
+// -------------------------------------------------
+
+type MockPermissionModel struct{}
+
+func (m MockPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	return AllPermissions, nil
+}
+
+func (m MockPermissionModel) AddForUser(userID int64, codes ...string) error {
+	return nil
+}
@@ -0,0 +1,491 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+)
+
+var (
+	ErrNotEnoughCards = errors.New("not enough cards")
+	ErrCardsNotFound  = errors.New("cards not found")
+)
+
+var PileNameRX = regexp.MustCompile("^[a-z0-9_-]{1,32}$")
+
+const (
+	PositionTop    = "top"
+	PositionBottom = "bottom"
+	PositionRandom = "random"
+)
+
+type Pile struct {
+	DeckID    string    `json:"deck_id"`
+	Name      string    `json:"name"`
+	Remaining int       `json:"remaining"`
+	Cards     []Card    `json:"cards,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int       `json:"-"`
+}
+
+// PileSelection picks cards out of a pile, either Count cards from the top or
+// the bottom of the pile, or the specific Cards given.
+type PileSelection struct {
+	Count    int
+	Position string
+	Cards    []Card
+}
+
+func ValidatePileName(v *validator.Validator, name string) {
+	v.Check(validator.Matches(name, PileNameRX), "pile", "must be 1 to 32 lowercase letters, digits, dashes or underscores")
+}
+
+func ValidatePileSelection(v *validator.Validator, sel PileSelection) {
+	if len(sel.Cards) > 0 {
+		v.Check(sel.Count == 0, "count", "must not be provided together with cards")
+		v.Check(sel.Position == "", "from", "must not be provided together with cards")
+		v.Check(len(sel.Cards) <= MaxCards, "cards", fmt.Sprintf("must not contain more than %d cards", MaxCards))
+		return
+	}
+
+	v.Check(sel.Count > 0, "count", "must be more than zero")
+	v.Check(sel.Count <= MaxCards, "count", fmt.Sprintf("must be equal or less than %d", MaxCards))
+	v.Check(validator.PermittedValue(sel.Position, []string{"", PositionTop, PositionBottom}), "from", "must be top or bottom")
+}
+
+// take splits cards into the selected cards and the ones left behind. Neither
+// returned slice shares memory with cards.
+func (sel PileSelection) take(cards []Card) (taken, rest []Card, err error) {
+	if len(sel.Cards) == 0 {
+		if sel.Count > len(cards) {
+			return nil, nil, ErrNotEnoughCards
+		}
+
+		split := sel.Count
+		if sel.Position == PositionBottom {
+			split = len(cards) - sel.Count
+		}
+
+		head := append([]Card{}, cards[:split]...)
+		tail := append([]Card{}, cards[split:]...)
+
+		if sel.Position == PositionBottom {
+			return tail, head, nil
+		}
+		return head, tail, nil
+	}
+
+	rest = append([]Card{}, cards...)
+
+	for _, card := range sel.Cards {
+		found := false
+
+		for i := range rest {
+			if rest[i] == card {
+				rest = append(rest[:i], rest[i+1:]...)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, nil, ErrCardsNotFound
+		}
+	}
+
+	taken = append([]Card{}, sel.Cards...)
+	return taken, rest, nil
+}
+
+// -------------------------------------------------
+
+type PileModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Deal moves count cards from the top of the deck onto the top of the named
+// pile, creating the pile if needed, and records it as a draw event.
+func (p PileModel) Deal(ctx context.Context, deckID, name string, count int, event *Event) (*Pile, error) {
+	ctx, cancel := queryContext(ctx, p.Timeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cards, err := lockDeckCards(ctx, tx, deckID)
+	if err != nil {
+		return nil, err
+	}
+
+	taken, rest, err := PileSelection{Count: count}.take(cards)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateDeckCards(ctx, tx, deckID, rest)
+	if err != nil {
+		return nil, err
+	}
+
+	pile, err := lockPile(ctx, tx, deckID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pile.Cards = append(taken, pile.Cards...)
+
+	err = savePile(ctx, tx, pile)
+	if err != nil {
+		return nil, err
+	}
+
+	event.DeckID = deckID
+	event.Action = EventDraw
+	event.Pile = name
+	event.Cards = taken
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return pile, tx.Commit()
+}
+
+func (p PileModel) Get(ctx context.Context, deckID, name string) (*Pile, error) {
+	ctx, cancel := queryContext(ctx, p.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT piles.deck_id, piles.name, piles.cards, piles.created_at, piles.version
+		FROM piles
+		INNER JOIN decks ON decks.id = piles.deck_id
+		WHERE piles.deck_id::text = $1 AND piles.name = $2
+		AND (decks.expires_at IS NULL OR decks.expires_at > NOW())`
+
+	var pile Pile
+
+	err := p.DB.QueryRowContext(ctx, query, deckID, name).Scan(
+		&pile.DeckID,
+		&pile.Name,
+		pq.Array(&pile.Cards),
+		&pile.CreatedAt,
+		&pile.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &pile, nil
+}
+
+// Draw removes the selected cards from the named pile and returns them, and
+// records it as a pile_draw event.
+func (p PileModel) Draw(ctx context.Context, deckID, name string, sel PileSelection, event *Event) ([]Card, error) {
+	ctx, cancel := queryContext(ctx, p.Timeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockDeckCards(ctx, tx, deckID)
+	if err != nil {
+		return nil, err
+	}
+
+	pile, err := lockPile(ctx, tx, deckID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if pile.Version == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, rest, err := sel.take(pile.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	pile.Cards = rest
+
+	err = savePile(ctx, tx, pile)
+	if err != nil {
+		return nil, err
+	}
+
+	event.DeckID = deckID
+	event.Action = EventPileDraw
+	event.Pile = name
+	event.Cards = taken
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return taken, tx.Commit()
+}
+
+// Move takes the selected cards from one pile and puts them on top of another,
+// creating the destination pile if needed, and records it as a pile_move
+// event.
+func (p PileModel) Move(ctx context.Context, deckID, from, to string, sel PileSelection, event *Event) (*Pile, error) {
+	ctx, cancel := queryContext(ctx, p.Timeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockDeckCards(ctx, tx, deckID)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := lockPile(ctx, tx, deckID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Version == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, rest, err := sel.take(source.Cards)
+	if err != nil {
+		return nil, err
+	}
+
+	source.Cards = rest
+
+	err = savePile(ctx, tx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := lockPile(ctx, tx, deckID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	destination.Cards = append(taken, destination.Cards...)
+
+	err = savePile(ctx, tx, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	event.DeckID = deckID
+	event.Action = EventPileMove
+	event.Pile = from
+	event.ToPile = to
+	event.Cards = taken
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return destination, tx.Commit()
+}
+
+// lockDeckCards locks the deck row for the rest of the transaction. Every pile
+// transfer takes this lock first, so transfers within a deck are serialised.
+// Closed decks can't be dealt from, so it returns ErrDeckClosed for them.
+func lockDeckCards(ctx context.Context, tx *sql.Tx, deckID string) ([]Card, error) {
+	err := lockDeck(ctx, tx, deckID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + remainingCards + `, closed
+		FROM decks
+		WHERE id::text = $1`
+
+	var cards []Card
+	var closed bool
+
+	err = tx.QueryRowContext(ctx, query, deckID).Scan(pq.Array(&cards), &closed)
+	if err != nil {
+		return nil, err
+	}
+
+	if closed {
+		return nil, ErrDeckClosed
+	}
+
+	return cards, nil
+}
+
+func updateDeckCards(ctx context.Context, tx *sql.Tx, deckID string, cards []Card) error {
+	query := `
+		UPDATE decks
+		SET updated_at = NOW(), version = version + 1
+		WHERE id::text = $1`
+
+	_, err := tx.ExecContext(ctx, query, deckID)
+	if err != nil {
+		return err
+	}
+
+	return saveDeckCards(ctx, tx, deckID, cards)
+}
+
+// lockPile returns the named pile, or an empty pile with a zero Version if it
+// doesn't exist yet.
+func lockPile(ctx context.Context, tx *sql.Tx, deckID, name string) (*Pile, error) {
+	query := `
+		SELECT cards, created_at, version
+		FROM piles
+		WHERE deck_id::text = $1 AND name = $2
+		FOR UPDATE`
+
+	pile := Pile{
+		DeckID: deckID,
+		Name:   name,
+	}
+
+	err := tx.QueryRowContext(ctx, query, deckID, name).Scan(pq.Array(&pile.Cards), &pile.CreatedAt, &pile.Version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &pile, nil
+}
+
+// pileCards returns the cards of every pile of the deck.
+func pileCards(ctx context.Context, tx *sql.Tx, deckID string) (map[string][]Card, error) {
+	query := `
+		SELECT name, cards
+		FROM piles
+		WHERE deck_id::text = $1
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, deckID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]Card)
+
+	for rows.Next() {
+		var name string
+		var cards []Card
+
+		err := rows.Scan(&name, pq.Array(&cards))
+		if err != nil {
+			return nil, err
+		}
+
+		result[name] = cards
+	}
+
+	return result, rows.Err()
+}
+
+func savePile(ctx context.Context, tx *sql.Tx, pile *Pile) error {
+	query := `
+		INSERT INTO piles (deck_id, name, cards)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (deck_id, name) DO UPDATE
+		SET cards = EXCLUDED.cards, version = piles.version + 1
+		RETURNING created_at, version`
+
+	if pile.Cards == nil {
+		pile.Cards = []Card{}
+	}
+
+	args := []any{pile.DeckID, pile.Name, pq.Array(pile.Cards)}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&pile.CreatedAt, &pile.Version)
+}
+
+// -------------------------------------------------
+
+type MockPileModel struct{}
+
+var MockPileName = "hand"
+
+func (m MockPileModel) Deal(ctx context.Context, deckID, name string, count int, event *Event) (*Pile, error) {
+	if deckID != MockID {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, _, err := PileSelection{Count: count}.take(MockCards)
+	if err != nil {
+		return nil, err
+	}
+
+	pile := Pile{
+		DeckID: deckID,
+		Name:   name,
+		Cards:  taken,
+	}
+
+	return &pile, nil
+}
+
+func (m MockPileModel) Get(ctx context.Context, deckID, name string) (*Pile, error) {
+	if deckID != MockID || name != MockPileName {
+		return nil, ErrRecordNotFound
+	}
+
+	pile := Pile{
+		DeckID: deckID,
+		Name:   name,
+		Cards:  MockCards,
+	}
+
+	return &pile, nil
+}
+
+func (m MockPileModel) Draw(ctx context.Context, deckID, name string, sel PileSelection, event *Event) ([]Card, error) {
+	if deckID != MockID || name != MockPileName {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, _, err := sel.take(MockCards)
+	return taken, err
+}
+
+func (m MockPileModel) Move(ctx context.Context, deckID, from, to string, sel PileSelection, event *Event) (*Pile, error) {
+	if deckID != MockID || from != MockPileName {
+		return nil, ErrRecordNotFound
+	}
+
+	taken, _, err := sel.take(MockCards)
+	if err != nil {
+		return nil, err
+	}
+
+	pile := Pile{
+		DeckID: deckID,
+		Name:   to,
+		Cards:  taken,
+	}
+
+	return &pile, nil
+}
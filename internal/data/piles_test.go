@@ -0,0 +1,61 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestPileSelectionTake(t *testing.T) {
+	cards, err := ParseCards([]string{"AS", "2S", "3S", "4S"})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name      string
+		sel       PileSelection
+		wantTaken string
+		wantRest  string
+	}{
+		{"top", PileSelection{Count: 2}, "AS 2S", "3S 4S"},
+		{"explicit top", PileSelection{Count: 1, Position: PositionTop}, "AS", "2S 3S 4S"},
+		{"bottom", PileSelection{Count: 2, Position: PositionBottom}, "3S 4S", "AS 2S"},
+		{"specific cards", PileSelection{Cards: []Card{cards[2], cards[0]}}, "3S AS", "2S 4S"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken, rest, err := tt.sel.take(cards)
+			assert.NilError(t, err)
+			assert.Equal(t, codes(taken), tt.wantTaken)
+			assert.Equal(t, codes(rest), tt.wantRest)
+		})
+	}
+
+	t.Run("leaves the original cards untouched", func(t *testing.T) {
+		assert.Equal(t, codes(cards), "AS 2S 3S 4S")
+	})
+
+	t.Run("fails when the pile is too small", func(t *testing.T) {
+		_, _, err := PileSelection{Count: 5}.take(cards)
+		assert.Equal(t, errors.Is(err, ErrNotEnoughCards), true)
+	})
+
+	t.Run("fails when a card isn't in the pile", func(t *testing.T) {
+		_, _, err := PileSelection{Cards: []Card{cards[0], cards[0]}}.take(cards)
+		assert.Equal(t, errors.Is(err, ErrCardsNotFound), true)
+	})
+}
+
+func codes(cards []Card) string {
+	var result string
+
+	for i, card := range cards {
+		if i > 0 {
+			result += " "
+		}
+		result += card.Code()
+	}
+
+	return result
+}
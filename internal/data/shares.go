@@ -0,0 +1,126 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scchi/cards/internal/validator"
+)
+
+const (
+	// ShareRoleSpectator may watch a deck.
+	ShareRoleSpectator = "spectator"
+	// ShareRoleDealer may watch a deck and draw from it.
+	ShareRoleDealer = "dealer"
+)
+
+var ErrInvalidShareToken = errors.New("invalid share token")
+
+// ShareToken grants access to a single deck without a user account. It isn't
+// stored anywhere: the deck, role, expiry and share version are signed with
+// HMAC-SHA256 instead. Bumping the deck's share version revokes every token
+// issued for it.
+type ShareToken struct {
+	Plaintext string    `json:"token"`
+	DeckID    string    `json:"deck_id"`
+	Role      string    `json:"role"`
+	Expiry    time.Time `json:"expiry"`
+	Version   int       `json:"-"`
+}
+
+// NewShareToken signs a token with the given role for the deck, valid for ttl.
+func NewShareToken(deck *Deck, role string, ttl time.Duration, secret []byte) *ShareToken {
+	token := &ShareToken{
+		DeckID:  deck.ID,
+		Role:    role,
+		Expiry:  time.Now().Add(ttl).Truncate(time.Second),
+		Version: deck.ShareVersion,
+	}
+
+	payload := fmt.Sprintf("%s.%s.%d.%d", token.DeckID, token.Role, token.Expiry.Unix(), token.Version)
+
+	token.Plaintext = base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signShare([]byte(payload), secret))
+
+	return token
+}
+
+// ParseShareToken checks the signature and expiry of a token. Whether the
+// token has been revoked depends on the deck and is left to the caller.
+func ParseShareToken(plaintext string, secret []byte) (*ShareToken, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(plaintext, ".")
+	if !ok {
+		return nil, ErrInvalidShareToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidShareToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return nil, ErrInvalidShareToken
+	}
+
+	if !hmac.Equal(mac, signShare(payload, secret)) {
+		return nil, ErrInvalidShareToken
+	}
+
+	fields := strings.Split(string(payload), ".")
+	if len(fields) != 4 {
+		return nil, ErrInvalidShareToken
+	}
+
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidShareToken
+	}
+
+	version, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, ErrInvalidShareToken
+	}
+
+	token := &ShareToken{
+		Plaintext: plaintext,
+		DeckID:    fields[0],
+		Role:      fields[1],
+		Expiry:    time.Unix(expiry, 0),
+		Version:   version,
+	}
+
+	if !time.Now().Before(token.Expiry) {
+		return nil, ErrInvalidShareToken
+	}
+
+	return token, nil
+}
+
+func signShare(payload, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Permissions returns what the holder of the token may do with its deck.
+func (t *ShareToken) Permissions() Permissions {
+	switch t.Role {
+	case ShareRoleDealer:
+		return Permissions{PermissionDecksRead, PermissionDecksDeal}
+	case ShareRoleSpectator:
+		return Permissions{PermissionDecksRead}
+	default:
+		return nil
+	}
+}
+
+func ValidateShareRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, []string{ShareRoleSpectator, ShareRoleDealer}), "role", "must be spectator or dealer")
+}
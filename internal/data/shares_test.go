@@ -0,0 +1,56 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestShareToken(t *testing.T) {
+	secret := []byte("secret")
+	deck := &Deck{ID: MockID, ShareVersion: 3}
+
+	t.Run("Parses the tokens it signs", func(t *testing.T) {
+		token := NewShareToken(deck, ShareRoleSpectator, time.Hour, secret)
+
+		got, err := ParseShareToken(token.Plaintext, secret)
+		assert.NilError(t, err)
+		assert.Equal(t, got.DeckID, MockID)
+		assert.Equal(t, got.Role, ShareRoleSpectator)
+		assert.Equal(t, got.Version, 3)
+		assert.Equal(t, got.Expiry.Equal(token.Expiry), true)
+	})
+
+	t.Run("Rejects tampered, foreign and expired tokens", func(t *testing.T) {
+		token := NewShareToken(deck, ShareRoleSpectator, time.Hour, secret)
+		payload, mac, _ := strings.Cut(token.Plaintext, ".")
+
+		dealer := NewShareToken(deck, ShareRoleDealer, time.Hour, secret)
+		dealerPayload, _, _ := strings.Cut(dealer.Plaintext, ".")
+
+		plaintexts := []string{
+			"",
+			payload,
+			dealerPayload + "." + mac,
+			NewShareToken(deck, ShareRoleSpectator, time.Hour, []byte("other")).Plaintext,
+			NewShareToken(deck, ShareRoleSpectator, -time.Second, secret).Plaintext,
+		}
+
+		for _, plaintext := range plaintexts {
+			_, err := ParseShareToken(plaintext, secret)
+			assert.Equal(t, err == ErrInvalidShareToken, true)
+		}
+	})
+
+	t.Run("Only dealers may deal", func(t *testing.T) {
+		spectator := &ShareToken{Role: ShareRoleSpectator}
+		dealer := &ShareToken{Role: ShareRoleDealer}
+
+		assert.Equal(t, spectator.Permissions().Include(PermissionDecksRead), true)
+		assert.Equal(t, spectator.Permissions().Include(PermissionDecksDeal), false)
+		assert.Equal(t, dealer.Permissions().Include(PermissionDecksDeal), true)
+		assert.Equal(t, dealer.Permissions().Include(PermissionDecksAdmin), false)
+	})
+}
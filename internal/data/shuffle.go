@@ -0,0 +1,260 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	AlgorithmFisherYates = "fisher-yates"
+	AlgorithmRiffle      = "riffle"
+	AlgorithmOverhand    = "overhand"
+
+	DefaultShuffleAlgorithm = AlgorithmFisherYates
+
+	// SeedSize is the size in bytes of the seeds used by the shufflers.
+	SeedSize = 32
+)
+
+var ShuffleAlgorithms = []string{AlgorithmFisherYates, AlgorithmRiffle, AlgorithmOverhand}
+
+var algorithms = map[string]func(cards []Card, src source){
+	AlgorithmFisherYates: fisherYates,
+	AlgorithmRiffle:      riffle,
+	AlgorithmOverhand:    overhand,
+}
+
+var ErrUnknownAlgorithm = errors.New("unknown shuffle algorithm")
+
+// A Shuffler shuffles cards in place with its algorithm. Every shuffle is
+// fully determined by the algorithm and the returned seed, so it can be
+// reproduced with a SeededShuffler.
+type Shuffler interface {
+	Algorithm() string
+	Shuffle(cards []Card) (seed []byte, err error)
+}
+
+// CryptoShuffler shuffles with a fresh seed from crypto/rand every time.
+type CryptoShuffler struct {
+	algorithm string
+}
+
+func NewCryptoShuffler(algorithm string) (*CryptoShuffler, error) {
+	if _, ok := algorithms[algorithm]; !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	return &CryptoShuffler{algorithm: algorithm}, nil
+}
+
+func (s *CryptoShuffler) Algorithm() string {
+	return s.algorithm
+}
+
+func (s *CryptoShuffler) Shuffle(cards []Card) ([]byte, error) {
+	seed := make([]byte, SeedSize)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithms[s.algorithm](cards, newSeededSource(seed))
+
+	return seed, nil
+}
+
+// SeededShuffler always shuffles with the same seed, which makes it useful for
+// replaying a recorded shuffle and in tests.
+type SeededShuffler struct {
+	algorithm string
+	seed      []byte
+}
+
+func NewSeededShuffler(algorithm string, seed []byte) (*SeededShuffler, error) {
+	if _, ok := algorithms[algorithm]; !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	if len(seed) != SeedSize {
+		return nil, fmt.Errorf("seed must be %d bytes", SeedSize)
+	}
+
+	// The seed is copied so a caller reusing its slice can't change what
+	// the shuffler replays.
+	return &SeededShuffler{algorithm: algorithm, seed: append([]byte{}, seed...)}, nil
+}
+
+func (s *SeededShuffler) Algorithm() string {
+	return s.algorithm
+}
+
+func (s *SeededShuffler) Shuffle(cards []Card) ([]byte, error) {
+	algorithms[s.algorithm](cards, newSeededSource(s.seed))
+
+	return append([]byte{}, s.seed...), nil
+}
+
+// ShuffleDeck shuffles the cards of the deck and records how it was done.
+func ShuffleDeck(deck *Deck, shuffler Shuffler) error {
+	seed, err := shuffler.Shuffle(deck.Cards)
+	if err != nil {
+		return err
+	}
+
+	deck.Shuffled = true
+	deck.ShuffleAlgorithm = shuffler.Algorithm()
+	deck.ShuffleSeed = seed
+
+	return nil
+}
+
+// ReshuffleDeck shuffles the cards left in the deck like ShuffleDeck and
+// records on event what it takes to replay the shuffle: the algorithm, the
+// seed and the hash of the order the cards were in before.
+func ReshuffleDeck(deck *Deck, shuffler Shuffler, event *Event) error {
+	input := HashCards(deck.Cards)
+
+	err := ShuffleDeck(deck, shuffler)
+	if err != nil {
+		return err
+	}
+
+	event.ShuffleAlgorithm = deck.ShuffleAlgorithm
+	event.ShuffleSeed = hex.EncodeToString(deck.ShuffleSeed)
+	event.InputHash = input
+
+	return nil
+}
+
+// -------------------------------------------------
+
+type source interface {
+	Intn(n int) int
+}
+
+// seededSource is a deterministic stream of random numbers derived from a
+// seed by hashing it together with a counter (SHA-256 in counter mode). With
+// a seed from crypto/rand the stream is as unpredictable as crypto/rand.
+type seededSource struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func newSeededSource(seed []byte) *seededSource {
+	return &seededSource{seed: seed}
+}
+
+// newCryptoSource returns a seededSource with a seed from crypto/rand.
+func newCryptoSource() (*seededSource, error) {
+	seed := make([]byte, SeedSize)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSeededSource(seed), nil
+}
+
+func (s *seededSource) uint64() uint64 {
+	if len(s.buf) < 8 {
+		block := make([]byte, len(s.seed)+8)
+		copy(block, s.seed)
+		binary.BigEndian.PutUint64(block[len(s.seed):], s.counter)
+		s.counter++
+
+		sum := sha256.Sum256(block)
+		s.buf = sum[:]
+	}
+
+	n := binary.BigEndian.Uint64(s.buf)
+	s.buf = s.buf[8:]
+
+	return n
+}
+
+// Intn returns a uniform number in [0, n), rejecting the values that would
+// bias the result towards small numbers.
+func (s *seededSource) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+
+	max := ^uint64(0) - ^uint64(0)%uint64(n)
+
+	for {
+		v := s.uint64()
+		if v < max {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// -------------------------------------------------
+
+func fisherYates(cards []Card, src source) {
+	for i := len(cards) - 1; i > 0; i-- {
+		j := src.Intn(i + 1)
+		cards[i], cards[j] = cards[j], cards[i]
+	}
+}
+
+// riffle simulates seven riffle shuffles using the Gilbert–Shannon–Reeds
+// model: the deck is cut binomially and cards drop from each half with a
+// probability proportional to the size of that half.
+func riffle(cards []Card, src source) {
+	const passes = 7
+
+	for pass := 0; pass < passes; pass++ {
+		cut := 0
+		for range cards {
+			cut += src.Intn(2)
+		}
+
+		left := append([]Card{}, cards[:cut]...)
+		right := append([]Card{}, cards[cut:]...)
+
+		for i := range cards {
+			if src.Intn(len(left)+len(right)) < len(left) {
+				cards[i], left = left[0], left[1:]
+			} else {
+				cards[i], right = right[0], right[1:]
+			}
+		}
+	}
+}
+
+// overhand simulates overhand shuffles: small packets are taken from the top
+// of the deck and dropped onto a new pile, which reverses their order.
+func overhand(cards []Card, src source) {
+	const passes = 20
+
+	if len(cards) < 2 {
+		return
+	}
+
+	maxPacket := len(cards)/8 + 1
+
+	for pass := 0; pass < passes; pass++ {
+		result := make([]Card, 0, len(cards))
+		rest := cards
+
+		for len(rest) > 0 {
+			size := src.Intn(maxPacket) + 1
+			if size > len(rest) {
+				size = len(rest)
+			}
+
+			result = append(append([]Card{}, rest[:size]...), result...)
+			rest = rest[size:]
+		}
+
+		copy(cards, result)
+	}
+}
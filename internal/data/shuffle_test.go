@@ -0,0 +1,128 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+)
+
+func TestShufflers(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+
+	for _, algorithm := range ShuffleAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			t.Run("keeps every card exactly once", func(t *testing.T) {
+				shuffler, err := NewCryptoShuffler(algorithm)
+				assert.NilError(t, err)
+
+				cards := GenerateShoe(2)
+				_, err = shuffler.Shuffle(cards)
+				assert.NilError(t, err)
+
+				assert.Equal(t, len(cards), 2*CardsPerDeck)
+				assert.Equal(t, maxRepeats(cards), 2)
+				assert.Equal(t, codes(cards) == codes(GenerateShoe(2)), false)
+			})
+
+			t.Run("replays a crypto shuffle from its seed", func(t *testing.T) {
+				shuffler, err := NewCryptoShuffler(algorithm)
+				assert.NilError(t, err)
+
+				cards := GenerateAllCards()
+				seed, err := shuffler.Shuffle(cards)
+				assert.NilError(t, err)
+
+				replayer, err := NewSeededShuffler(algorithm, seed)
+				assert.NilError(t, err)
+
+				replayed := GenerateAllCards()
+				_, err = replayer.Shuffle(replayed)
+				assert.NilError(t, err)
+
+				assert.Equal(t, codes(replayed), codes(cards))
+			})
+
+			t.Run("is deterministic for a seed", func(t *testing.T) {
+				shuffler, err := NewSeededShuffler(algorithm, seed)
+				assert.NilError(t, err)
+
+				first := GenerateAllCards()
+				second := GenerateAllCards()
+
+				shuffler.Shuffle(first)
+				shuffler.Shuffle(second)
+
+				assert.Equal(t, codes(first), codes(second))
+			})
+		})
+	}
+
+	t.Run("Two crypto shuffles give different orders", func(t *testing.T) {
+		shuffler, err := NewCryptoShuffler(DefaultShuffleAlgorithm)
+		assert.NilError(t, err)
+
+		first := GenerateAllCards()
+		second := GenerateAllCards()
+
+		shuffler.Shuffle(first)
+		shuffler.Shuffle(second)
+
+		assert.Equal(t, codes(first) == codes(second), false)
+	})
+
+	t.Run("Keeps its own copy of the seed", func(t *testing.T) {
+		mutable := bytes.Repeat([]byte{7}, SeedSize)
+
+		shuffler, err := NewSeededShuffler(DefaultShuffleAlgorithm, mutable)
+		assert.NilError(t, err)
+
+		first := GenerateAllCards()
+		shuffler.Shuffle(first)
+
+		mutable[0] = 8
+		got, _ := shuffler.Shuffle(GenerateAllCards())
+		got[1] = 9
+
+		second := GenerateAllCards()
+		shuffler.Shuffle(second)
+
+		assert.Equal(t, codes(first), codes(second))
+	})
+
+	t.Run("Rejects unknown algorithms and short seeds", func(t *testing.T) {
+		_, err := NewCryptoShuffler("bogo")
+		assert.Equal(t, err == ErrUnknownAlgorithm, true)
+
+		_, err = NewSeededShuffler(DefaultShuffleAlgorithm, []byte{1})
+		assert.Equal(t, err == nil, false)
+	})
+}
+
+func TestShuffleDeck(t *testing.T) {
+	shuffler, err := NewCryptoShuffler(AlgorithmRiffle)
+	assert.NilError(t, err)
+
+	deck := Deck{Cards: GenerateAllCards()}
+
+	err = ShuffleDeck(&deck, shuffler)
+	assert.NilError(t, err)
+
+	assert.Equal(t, deck.Shuffled, true)
+	assert.Equal(t, deck.ShuffleAlgorithm, AlgorithmRiffle)
+	assert.Equal(t, len(deck.ShuffleSeed), SeedSize)
+}
+
+func maxRepeats(cards []Card) int {
+	counts := make(map[Card]int)
+	max := 0
+
+	for _, card := range cards {
+		counts[card]++
+		if counts[card] > max {
+			max = counts[card]
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,737 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/migrations"
+)
+
+// storageBackends open empty models for every backend the behavioural suite
+// runs against. PostgreSQL only runs when CARDS_TEST_DB_DSN is set.
+var storageBackends = map[string]func(t *testing.T) Models{
+	"memory": func(t *testing.T) Models {
+		return NewMemoryModels(NewMemoryStore())
+	},
+	"file": func(t *testing.T) Models {
+		store, err := OpenFileStore(filepath.Join(t.TempDir(), "cards.jsonl"))
+		assert.NilError(t, err)
+		t.Cleanup(func() { store.Close() })
+
+		return NewMemoryModels(store)
+	},
+	"postgres": func(t *testing.T) Models {
+		dsn := os.Getenv("CARDS_TEST_DB_DSN")
+		if dsn == "" {
+			t.Skip("CARDS_TEST_DB_DSN not set")
+		}
+
+		db, err := sql.Open("postgres", dsn)
+		assert.NilError(t, err)
+
+		runScript(t, db, "test/setup.sql")
+		t.Cleanup(func() {
+			runScript(t, db, "test/teardown.sql")
+			db.Close()
+		})
+
+		return NewModels(db, DefaultQueryTimeout)
+	},
+}
+
+func runScript(tb testing.TB, db *sql.DB, path string) {
+	script, err := fs.ReadFile(migrations.Test, path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	_, err = db.Exec(string(script))
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestStorageBackends(t *testing.T) {
+	for name, open := range storageBackends {
+		t.Run(name, func(t *testing.T) {
+			testStorage(t, open)
+		})
+	}
+}
+
+// testStorage is the behaviour every backend must share.
+func testStorage(t *testing.T, open func(t *testing.T) Models) {
+	ctx := context.Background()
+
+	insert := func(t *testing.T, m Models, cards string, modify ...func(*Deck)) *Deck {
+		parsed, err := ParseCards(strings.Fields(cards))
+		assert.NilError(t, err)
+
+		deck := &Deck{DeckCount: 1, Cards: parsed, ShuffleAlgorithm: DefaultShuffleAlgorithm}
+		for _, f := range modify {
+			f(deck)
+		}
+
+		assert.NilError(t, m.Decks.Insert(ctx, deck, &Event{}))
+
+		return deck
+	}
+
+	draw := func(t *testing.T, m Models, id string, count int) []Card {
+		drawn, err := m.Decks.Draw(ctx, id, count, &Event{})
+		assert.NilError(t, err)
+
+		return drawn.Cards
+	}
+
+	t.Run("Gets the deck it inserted", func(t *testing.T) {
+		m := open(t)
+
+		deck := insert(t, m, "AS 2S 3S", func(d *Deck) {
+			d.Labels = Labels{"table": "7"}
+		})
+		assert.Equal(t, len(deck.ID), 36)
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "AS 2S 3S")
+		assert.Equal(t, codes(got.InitialCards), "AS 2S 3S")
+		assert.Equal(t, got.Labels["table"], "7")
+		assert.Equal(t, got.OwnerID == nil, true)
+		assert.Equal(t, got.Version, 1)
+		assert.Equal(t, got.ShareVersion, 1)
+		assert.Equal(t, len(got.Piles), 0)
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Action, EventCreate)
+		assert.Equal(t, len(events[0].Cards), 0)
+	})
+
+	t.Run("Hides unknown and expired decks", func(t *testing.T) {
+		m := open(t)
+
+		_, err := m.Decks.Get(ctx, "00000000-0000-4000-8000-000000000000")
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+
+		expired := time.Now().Add(-time.Minute)
+		deck := insert(t, m, "AS", func(d *Deck) { d.ExpiresAt = &expired })
+
+		_, err = m.Decks.Get(ctx, deck.ID)
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+	})
+
+	t.Run("Draws from the top of the deck", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S")
+
+		event := &Event{RequestID: "abc"}
+		drawn, err := m.Decks.Draw(ctx, deck.ID, 2, event)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(drawn.Cards), "AS 2S")
+		assert.Equal(t, drawn.Version, deck.Version+1)
+		assert.Equal(t, event.Action, EventDraw)
+		assert.Equal(t, codes(event.Cards), "AS 2S")
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "3S")
+		assert.Equal(t, got.Version, drawn.Version)
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[1].ID, event.ID)
+		assert.Equal(t, events[1].RequestID, "abc")
+
+		_, err = m.Decks.Draw(ctx, deck.ID, 2, &Event{})
+		assert.Equal(t, errors.Is(err, ErrNotEnoughCards), true)
+
+		draw(t, m, deck.ID, 1)
+
+		_, err = m.Decks.Draw(ctx, deck.ID, 1, &Event{})
+		assert.Equal(t, errors.Is(err, ErrDeckDealt), true)
+
+		_, err = m.Decks.Draw(ctx, "b23d446a-f01a-4d6e-bec3-f928a3457ac7", 1, &Event{})
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+	})
+
+	t.Run("Records how to replay every shuffle", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S 4S 5S 6S 7S 8S")
+
+		shuffler, err := NewCryptoShuffler(AlgorithmRiffle)
+		assert.NilError(t, err)
+
+		var before []Card
+
+		for i := 0; i < 2; i++ {
+			current, err := m.Decks.Get(ctx, deck.ID)
+			assert.NilError(t, err)
+
+			before = append([]Card{}, current.Cards...)
+
+			event := &Event{Action: EventShuffle}
+			assert.NilError(t, ReshuffleDeck(current, shuffler, event))
+			assert.NilError(t, m.Decks.Update(ctx, current, event))
+		}
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 3)
+
+		second := events[2]
+		assert.Equal(t, second.Action, EventShuffle)
+		assert.Equal(t, second.ShuffleAlgorithm, AlgorithmRiffle)
+		assert.Equal(t, second.InputHash, HashCards(before))
+
+		seed, err := hex.DecodeString(second.ShuffleSeed)
+		assert.NilError(t, err)
+
+		replay, err := NewSeededShuffler(second.ShuffleAlgorithm, seed)
+		assert.NilError(t, err)
+
+		_, err = replay.Shuffle(before)
+		assert.NilError(t, err)
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), codes(before))
+	})
+
+	t.Run("Rejects stale updates", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S")
+
+		stale, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+
+		draw(t, m, deck.ID, 1)
+
+		stale.Cards = stale.Cards[1:]
+		err = m.Decks.Update(ctx, stale, &Event{Action: EventDraw, Cards: stale.Cards[:1]})
+		assert.Equal(t, errors.Is(err, ErrEditConflict), true)
+	})
+
+	t.Run("Undoes the latest draws", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S 4S")
+
+		version := func() int {
+			got, err := m.Decks.Get(ctx, deck.ID)
+			assert.NilError(t, err)
+			return got.Version
+		}
+
+		_, err := m.Decks.Undo(ctx, deck.ID, 1, version(), &Event{})
+		assert.Equal(t, errors.Is(err, ErrNothingToUndo), true)
+
+		draw(t, m, deck.ID, 1)
+		draw(t, m, deck.ID, 2)
+
+		got, err := m.Decks.Undo(ctx, deck.ID, 1, version(), &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "2S 3S 4S")
+
+		got, err = m.Decks.Undo(ctx, deck.ID, 1, version(), &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "AS 2S 3S 4S")
+
+		_, err = m.Decks.Undo(ctx, deck.ID, 1, version(), &Event{})
+		assert.Equal(t, errors.Is(err, ErrNothingToUndo), true)
+	})
+
+	t.Run("Refuses to undo a deck that moved past the version the client saw", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S")
+
+		mine, err := m.Decks.Draw(ctx, deck.ID, 1, &Event{})
+		assert.NilError(t, err)
+
+		theirs, err := m.Decks.Draw(ctx, deck.ID, 1, &Event{})
+		assert.NilError(t, err)
+
+		_, err = m.Decks.Undo(ctx, deck.ID, 1, mine.Version, &Event{})
+		assert.Equal(t, errors.Is(err, ErrEditConflict), true)
+
+		got, err := m.Decks.Undo(ctx, deck.ID, 1, theirs.Version, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "2S 3S")
+	})
+
+	t.Run("Returns dealt cards but not cards in piles", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S 4S")
+
+		draw(t, m, deck.ID, 1)
+
+		pile, err := m.Piles.Deal(ctx, deck.ID, "hand", 1, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(pile.Cards), "2S")
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, got.Piles["hand"], 1)
+
+		got, err = m.Decks.Return(ctx, deck.ID, nil, PositionBottom, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "3S 4S AS")
+
+		_, err = m.Decks.Return(ctx, deck.ID, pile.Cards, "", &Event{})
+		assert.Equal(t, errors.Is(err, ErrCardsNotFound), true)
+	})
+
+	t.Run("Refuses returns to a committed deck once it is revealable", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S", func(d *Deck) { assert.NilError(t, CommitDeck(d)) })
+
+		_, err := m.Piles.Deal(ctx, deck.ID, "hand", 1, &Event{})
+		assert.NilError(t, err)
+
+		draw(t, m, deck.ID, 2)
+
+		got, err := m.Decks.Return(ctx, deck.ID, nil, "", &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "2S 3S")
+
+		draw(t, m, deck.ID, 2)
+
+		_, err = m.Piles.Draw(ctx, deck.ID, "hand", PileSelection{Count: 1}, &Event{})
+		assert.NilError(t, err)
+
+		_, err = m.Decks.Return(ctx, deck.ID, nil, "", &Event{})
+		assert.Equal(t, errors.Is(err, ErrDeckRevealed), true)
+	})
+
+	t.Run("Refuses to change closed decks", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S")
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+
+		got.Closed = true
+		assert.NilError(t, m.Decks.Update(ctx, got, &Event{Action: EventClose}))
+
+		_, err = m.Decks.Return(ctx, deck.ID, nil, "", &Event{})
+		assert.Equal(t, errors.Is(err, ErrDeckClosed), true)
+
+		_, err = m.Piles.Deal(ctx, deck.ID, "hand", 1, &Event{})
+		assert.Equal(t, errors.Is(err, ErrDeckClosed), true)
+	})
+
+	t.Run("Moves cards between piles", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S")
+
+		_, err := m.Piles.Deal(ctx, deck.ID, "hand", 2, &Event{})
+		assert.NilError(t, err)
+
+		pile, err := m.Piles.Move(ctx, deck.ID, "hand", "table", PileSelection{Count: 1, Position: PositionBottom}, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(pile.Cards), "2S")
+
+		cards, err := m.Piles.Draw(ctx, deck.ID, "hand", PileSelection{Count: 1}, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, codes(cards), "AS")
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 4)
+		assert.Equal(t, events[2].Action, EventPileMove)
+		assert.Equal(t, events[2].Pile, "hand")
+		assert.Equal(t, events[2].ToPile, "table")
+		assert.Equal(t, codes(events[2].Cards), "2S")
+		assert.Equal(t, events[3].Action, EventPileDraw)
+		assert.Equal(t, events[3].Pile, "hand")
+		assert.Equal(t, codes(events[3].Cards), "AS")
+
+		_, err = m.Piles.Get(ctx, deck.ID, "nowhere")
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+	})
+
+	t.Run("Lists, filters, sorts and pages decks", func(t *testing.T) {
+		m := open(t)
+		owner := int64(0)
+
+		insert(t, m, "AS", func(d *Deck) { d.Labels = Labels{"game": "poker"} })
+		insert(t, m, "AS 2S 3S", func(d *Deck) { d.Labels = Labels{"game": "poker"} })
+		insert(t, m, "AS 2S", func(d *Deck) { d.Labels = Labels{"game": "snap"} })
+
+		filters := DeckFilters{
+			MaxRemaining: MaxCards,
+			Labels:       Labels{"game": "poker"},
+			Filters:      Filters{Page: 1, PageSize: 1, Sort: "-remaining", SortSafelist: DeckSortSafelist},
+		}
+
+		decks, metadata, err := m.Decks.GetAll(ctx, filters)
+		assert.NilError(t, err)
+		assert.Equal(t, len(decks), 1)
+		assert.Equal(t, decks[0].Remaining, 3)
+		assert.Equal(t, len(decks[0].Cards), 0)
+		assert.Equal(t, metadata.TotalRecords, 2)
+		assert.Equal(t, metadata.LastPage, 2)
+
+		filters.Page = 2
+		decks, _, err = m.Decks.GetAll(ctx, filters)
+		assert.NilError(t, err)
+		assert.Equal(t, decks[0].Remaining, 1)
+
+		filters.OwnerID = &owner
+		decks, metadata, err = m.Decks.GetAll(ctx, filters)
+		assert.NilError(t, err)
+		assert.Equal(t, len(decks), 0)
+		assert.Equal(t, metadata.TotalRecords, 0)
+	})
+
+	t.Run("Deletes decks but keeps their events", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS")
+
+		assert.NilError(t, m.Decks.Delete(ctx, deck.ID, &Event{}))
+
+		_, err := m.Decks.Get(ctx, deck.ID)
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+
+		err = m.Decks.Delete(ctx, deck.ID, &Event{})
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, events[1].Action, EventDelete)
+	})
+
+	t.Run("Pages events with a cursor", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS 2S 3S")
+
+		draw(t, m, deck.ID, 1)
+		draw(t, m, deck.ID, 1)
+
+		events, metadata, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 2})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+		assert.Equal(t, metadata.NextCursor, events[1].ID)
+
+		events, _, err = m.Events.GetAllForDeck(ctx, deck.ID, Cursor{After: metadata.NextCursor, Limit: 2})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, codes(events[0].Cards), "2S")
+	})
+
+	t.Run("Revokes shares", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, "AS")
+
+		assert.NilError(t, m.Decks.RevokeShares(ctx, deck.ID))
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, got.ShareVersion, 2)
+
+		err = m.Decks.RevokeShares(ctx, "00000000-0000-4000-8000-000000000000")
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+	})
+
+	t.Run("Purges expired decks and replaces their events with a purge", func(t *testing.T) {
+		m := open(t)
+
+		expired := time.Now().Add(-time.Minute)
+		gone := insert(t, m, "AS", func(d *Deck) { d.ExpiresAt = &expired })
+		kept := insert(t, m, "AS")
+
+		count, err := m.Decks.Purge(ctx, 0, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, count, int64(1))
+
+		_, err = m.Decks.Get(ctx, gone.ID)
+		assert.Equal(t, errors.Is(err, ErrRecordNotFound), true)
+
+		events, _, err := m.Events.GetAllForDeck(ctx, gone.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Action, EventPurge)
+
+		_, err = m.Decks.Get(ctx, kept.ID)
+		assert.NilError(t, err)
+
+		events, _, err = m.Events.GetAllForDeck(ctx, kept.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Action, EventCreate)
+	})
+
+	t.Run("Never deals a card twice under concurrent draws", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, strings.Join(cardCodesOf(GenerateAllCards()), " "))
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := make(map[Card]int)
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for drawn := 0; drawn < 4; {
+					current, err := m.Decks.Get(ctx, deck.ID)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					cards := current.Cards[:1]
+					current.Cards = current.Cards[1:]
+
+					err = m.Decks.Update(ctx, current, &Event{Action: EventDraw, Cards: cards})
+					if errors.Is(err, ErrEditConflict) {
+						continue
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mu.Lock()
+					seen[cards[0]]++
+					mu.Unlock()
+
+					drawn++
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, len(seen), 32)
+		assert.Equal(t, len(got.Cards), CardsPerDeck-32)
+	})
+
+	t.Run("Never deals a card twice under concurrent atomic draws", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, strings.Join(cardCodesOf(GenerateAllCards()), " "))
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := make(map[Card]int)
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for drawn := 0; drawn < 4; drawn++ {
+					got, err := m.Decks.Draw(ctx, deck.ID, 1, &Event{})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mu.Lock()
+					seen[got.Cards[0]]++
+					mu.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		got, err := m.Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, len(seen), 32)
+		assert.Equal(t, len(got.Cards), CardsPerDeck-32)
+		assert.Equal(t, got.Version, deck.Version+32)
+	})
+
+	t.Run("Never loses cards when draws race shuffles and returns", func(t *testing.T) {
+		m := open(t)
+		deck := insert(t, m, strings.Join(cardCodesOf(GenerateAllCards()), " "))
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for n := 0; n < 20; n++ {
+					got, err := m.Decks.Draw(ctx, deck.ID, 2, &Event{})
+					if errors.Is(err, ErrNotEnoughCards) || errors.Is(err, ErrDeckDealt) {
+						continue
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					if len(got.Cards) != 2 {
+						t.Errorf("drew %d cards, want 2", len(got.Cards))
+					}
+				}
+			}()
+		}
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < 20; {
+				current, err := m.Decks.Get(ctx, deck.ID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// Rotating the deck is enough to write a new order.
+				if len(current.Cards) > 1 {
+					current.Cards = append(current.Cards[1:], current.Cards[0])
+				}
+
+				err = m.Decks.Update(ctx, current, &Event{Action: EventShuffle})
+				if errors.Is(err, ErrEditConflict) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				n++
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < 20; n++ {
+				_, err := m.Decks.Return(ctx, deck.ID, nil, PositionBottom, &Event{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		wg.Wait()
+
+		got, err := m.Decks.Return(ctx, deck.ID, nil, PositionBottom, &Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(got.Cards), CardsPerDeck)
+
+		seen := make(map[Card]bool)
+		for _, card := range got.Cards {
+			seen[card] = true
+		}
+
+		assert.Equal(t, len(seen), CardsPerDeck)
+	})
+}
+
+func cardCodesOf(cards []Card) []string {
+	result := make([]string, len(cards))
+	for i, card := range cards {
+		result[i] = card.Code()
+	}
+
+	return result
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "cards.jsonl")
+
+	store, err := OpenFileStore(path)
+	assert.NilError(t, err)
+
+	m := NewMemoryModels(store)
+
+	cards, err := ParseCards([]string{"AS", "2S", "3S"})
+	assert.NilError(t, err)
+
+	deck := &Deck{DeckCount: 1, Cards: cards}
+	assert.NilError(t, m.Decks.Insert(ctx, deck, &Event{}))
+
+	_, err = m.Piles.Deal(ctx, deck.ID, "hand", 1, &Event{})
+	assert.NilError(t, err)
+
+	reopen := func(t *testing.T) Models {
+		store, err := OpenFileStore(path)
+		assert.NilError(t, err)
+		t.Cleanup(func() { store.Close() })
+
+		return NewMemoryModels(store)
+	}
+
+	t.Run("Replays the log", func(t *testing.T) {
+		// Leave the file without a snapshot, as after a crash.
+		assert.NilError(t, store.file.close())
+
+		got, err := reopen(t).Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "2S 3S")
+		assert.Equal(t, got.Piles["hand"], 1)
+	})
+
+	t.Run("Drops a record cut short by a crash", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		assert.NilError(t, err)
+		f.WriteString(`{"decks":[{"id":`)
+		f.Close()
+
+		got, err := reopen(t).Decks.Get(ctx, deck.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, codes(got.Cards), "2S 3S")
+	})
+
+	t.Run("Refuses a damaged file", func(t *testing.T) {
+		damaged := filepath.Join(t.TempDir(), "damaged.jsonl")
+		assert.NilError(t, os.WriteFile(damaged, []byte("{}\nnot json\n{}\n"), 0o600))
+
+		_, err := OpenFileStore(damaged)
+		assert.Equal(t, err != nil, true)
+	})
+
+	t.Run("Compacts the log into a snapshot on close", func(t *testing.T) {
+		store, err := OpenFileStore(path)
+		assert.NilError(t, err)
+
+		m := NewMemoryModels(store)
+
+		events, _, err := m.Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+
+		assert.NilError(t, m.Health.Ping(context.Background()))
+		assert.NilError(t, store.Close())
+
+		contents, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, strings.Count(string(contents), "\n"), 1)
+		assert.Equal(t, strings.HasPrefix(string(contents), `{"snapshot":true`), true)
+
+		events, _, err = reopen(t).Events.GetAllForDeck(ctx, deck.ID, Cursor{Limit: 10})
+		assert.NilError(t, err)
+		assert.Equal(t, len(events), 2)
+	})
+}
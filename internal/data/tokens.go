@@ -0,0 +1,109 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"time"
+
+	"github.com/scchi/cards/internal/validator"
+)
+
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+)
+
+// Token is a random bearer token. Only the SHA-256 hash of the plaintext is
+// stored, so a leaked tokens table can't be used to authenticate.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := hashToken(token.Plaintext)
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func hashToken(plaintext string) [32]byte {
+	return sha256.Sum256([]byte(plaintext))
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// -------------------------------------------------
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// New generates a token and stores its hash.
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m TokenModel) Insert(token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	_, err := m.DB.Exec(query, args...)
+	return err
+}
+
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2`
+
+	_, err := m.DB.Exec(query, scope, userID)
+	return err
+}
+
+// -------------------------------------------------
+
+type MockTokenModel struct{}
+
+func (m MockTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return generateToken(userID, ttl, scope)
+}
+
+func (m MockTokenModel) Insert(token *Token) error {
+	return nil
+}
+
+func (m MockTokenModel) DeleteAllForUser(scope string, userID int64) error {
+	return nil
+}
@@ -0,0 +1,390 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scchi/cards/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrDuplicateEmail = errors.New("duplicate email")
+
+	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+)
+
+// AnonymousUser is the user of requests without an authentication token.
+var AnonymousUser = &User{}
+
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Version   int       `json:"-"`
+}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// Owns reports whether the user may use the deck. Decks created without an
+// authentication token have no owner, so every user owns them, anonymous or
+// not: anyone holding the permission may view, deal from, close or delete
+// them.
+func (u *User) Owns(deck *Deck) bool {
+	return deck.OwnerID == nil || (!u.IsAnonymous() && *deck.OwnerID == u.ID)
+}
+
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+
+	return nil
+}
+
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, EmailRX), "email", "must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+// -------------------------------------------------
+
+type UserModel struct {
+	DB *sql.DB
+}
+
+func (m UserModel) Insert(user *User) error {
+	query := `
+		INSERT INTO users (name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	err := m.DB.QueryRow(query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Register inserts the user together with its permissions and an activation
+// token lasting activationTTL, in one transaction. A failure part way would
+// otherwise leave an account that can't be activated, or used, under an email
+// address that can't be registered again.
+func (m UserModel) Register(user *User, activationTTL time.Duration, codes ...string) (*Token, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	err = tx.QueryRow(query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return nil, ErrDuplicateEmail
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.Exec(query, user.ID, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(user.ID, activationTTL, ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
+}
+
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE email = $1`
+
+	var user User
+
+	err := m.DB.QueryRow(query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	err := m.DB.QueryRow(query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the user holding the unexpired token with the given
+// scope and plaintext.
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := hashToken(tokenPlaintext)
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	err := m.DB.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// -------------------------------------------------
+
+// MockUserModel knows a single activated user with the email MockUserEmail
+// and password MockUserPassword, who holds MockToken in every scope.
+type MockUserModel struct{}
+
+var (
+	MockUserID       int64 = 1
+	MockUserEmail          = "alice@example.com"
+	MockUserPassword       = "pa55word1234"
+	MockToken              = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+func (m MockUserModel) mockUser() (*User, error) {
+	user := &User{
+		ID:        MockUserID,
+		Name:      "Alice",
+		Email:     MockUserEmail,
+		Activated: true,
+	}
+
+	// A low cost keeps the tests fast; bcrypt's minimum is 4.
+	hash, err := bcrypt.GenerateFromPassword([]byte(MockUserPassword), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password.hash = hash
+
+	return user, nil
+}
+
+func (m MockUserModel) Insert(user *User) error {
+	if user.Email == MockUserEmail {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = MockUserID + 1
+	return nil
+}
+
+func (m MockUserModel) Register(user *User, activationTTL time.Duration, codes ...string) (*Token, error) {
+	err := m.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return generateToken(user.ID, activationTTL, ScopeActivation)
+}
+
+func (m MockUserModel) Get(id int64) (*User, error) {
+	if id != MockUserID {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.mockUser()
+}
+
+func (m MockUserModel) GetByEmail(email string) (*User, error) {
+	if email != MockUserEmail {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.mockUser()
+}
+
+func (m MockUserModel) Update(user *User) error {
+	return nil
+}
+
+func (m MockUserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	if tokenPlaintext != MockToken {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.mockUser()
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/internal/validator"
+)
+
+func TestPassword(t *testing.T) {
+	var p password
+
+	err := p.Set("pa55word1234")
+	assert.NilError(t, err)
+
+	t.Run("Matches the password it was set to", func(t *testing.T) {
+		match, err := p.Matches("pa55word1234")
+		assert.NilError(t, err)
+		assert.Equal(t, match, true)
+	})
+
+	t.Run("Doesn't match another password", func(t *testing.T) {
+		match, err := p.Matches("pa55word12345")
+		assert.NilError(t, err)
+		assert.Equal(t, match, false)
+	})
+
+	t.Run("Rejects short passwords", func(t *testing.T) {
+		var short password
+		assert.NilError(t, short.Set("short"))
+
+		v := validator.New()
+		ValidateUser(v, &User{Name: "Alice", Email: "alice@example.com", Password: short})
+		assert.Equal(t, v.Errors["password"], "must be at least 8 bytes long")
+	})
+}
+
+func TestUserOwns(t *testing.T) {
+	ownerID := int64(7)
+
+	owned := &Deck{OwnerID: &ownerID}
+	unowned := &Deck{}
+
+	tests := []struct {
+		name string
+		user *User
+		deck *Deck
+		want bool
+	}{
+		{"The owner owns the deck", &User{ID: 7}, owned, true},
+		{"Other users don't", &User{ID: 8}, owned, false},
+		{"Anonymous users don't", AnonymousUser, owned, false},
+		{"Anonymous users may use decks without an owner", AnonymousUser, unowned, true},
+		{"So may every authenticated user", &User{ID: 8}, unowned, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.user.Owns(tt.deck), tt.want)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/scchi/cards/internal/jsonlog"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends the email in templateFile, rendered with data, to recipient.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// message is a rendered email.
+type message struct {
+	subject   string
+	plainBody string
+	htmlBody  string
+}
+
+// render executes the subject, plainBody and htmlBody templates of
+// templateFile. Only htmlBody goes through html/template: the subject and the
+// plain text part are not HTML, and escaping them would turn a name like
+// O'Brien into O&#39;Brien.
+func render(templateFile string, data any) (*message, error) {
+	text, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg message
+
+	for _, part := range []struct {
+		name string
+		tmpl interface {
+			ExecuteTemplate(w io.Writer, name string, data any) error
+		}
+		dst *string
+	}{
+		{"subject", text, &msg.subject},
+		{"plainBody", text, &msg.plainBody},
+		{"htmlBody", html, &msg.htmlBody},
+	} {
+		buf := new(bytes.Buffer)
+
+		err = part.tmpl.ExecuteTemplate(buf, part.name, data)
+		if err != nil {
+			return nil, err
+		}
+
+		*part.dst = buf.String()
+	}
+
+	return &msg, nil
+}
+
+// -------------------------------------------------
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// NewSMTP returns a mailer for the server at host:port. Without a username
+// it sends without authenticating, e.g. to a local SMTP stand-in.
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		sender: sender,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send tries to deliver the email up to three times, half a second apart, to
+// ride out short outages of the SMTP server.
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(m.sender)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.mime(m.sender, recipient)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, from.Address, []string{recipient}, body)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
+
+// mime encodes the message as a multipart/alternative email with a plain
+// text and an HTML part.
+func (msg *message) mime(sender, recipient string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", recipient)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", msg.plainBody},
+		{"text/html", msg.htmlBody},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+
+		_, err = qp.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// -------------------------------------------------
+
+// LogMailer writes the plain text of every email to a logger instead of
+// sending it, for development and tests.
+type LogMailer struct {
+	logger *jsonlog.Logger
+	sender string
+}
+
+func NewLog(logger *jsonlog.Logger, sender string) *LogMailer {
+	return &LogMailer{
+		logger: logger,
+		sender: sender,
+	}
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.logger.PrintInfo("email", map[string]string{
+		"from":    m.sender,
+		"to":      recipient,
+		"subject": msg.subject,
+		"body":    msg.plainBody,
+	})
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/internal/jsonlog"
+)
+
+var welcomeData = map[string]any{
+	"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"name":            "Alice",
+	"userID":          7,
+}
+
+func TestRender(t *testing.T) {
+	msg, err := render("user_welcome.tmpl", welcomeData)
+	assert.NilError(t, err)
+
+	assert.Equal(t, msg.subject, "Welcome to Cards!")
+	assert.Equal(t, strings.Contains(msg.plainBody, `{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`), true)
+	assert.Equal(t, strings.Contains(msg.htmlBody, "<p>Hi Alice,</p>"), true)
+
+	t.Run("Only escapes the HTML part", func(t *testing.T) {
+		msg, err := render("user_welcome.tmpl", map[string]any{
+			"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			"name":            "Seán O'Brien & co",
+			"userID":          7,
+		})
+		assert.NilError(t, err)
+
+		assert.Equal(t, strings.Contains(msg.plainBody, "Hi Seán O'Brien & co,"), true)
+		assert.Equal(t, strings.Contains(msg.htmlBody, "<p>Hi Seán O&#39;Brien &amp; co,</p>"), true)
+	})
+
+	t.Run("Fails for unknown templates", func(t *testing.T) {
+		_, err := render("missing.tmpl", nil)
+		assert.Equal(t, err != nil, true)
+	})
+}
+
+func TestMIME(t *testing.T) {
+	msg, err := render("user_welcome.tmpl", welcomeData)
+	assert.NilError(t, err)
+
+	body, err := msg.mime("Cards <no-reply@cards.local>", "alice@example.com")
+	assert.NilError(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(body))
+	assert.NilError(t, err)
+
+	assert.Equal(t, parsed.Header.Get("To"), "alice@example.com")
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.NilError(t, err)
+	assert.Equal(t, subject, "Welcome to Cards!")
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NilError(t, err)
+	assert.Equal(t, mediaType, "multipart/alternative")
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+
+	var contentTypes []string
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+	}
+
+	assert.Equal(t, strings.Join(contentTypes, ", "), "text/plain; charset=utf-8, text/html; charset=utf-8")
+}
+
+func TestLogMailer(t *testing.T) {
+	var logs bytes.Buffer
+
+	m := NewLog(jsonlog.New(&logs, jsonlog.LevelInfo), "Cards <no-reply@cards.local>")
+
+	err := m.Send("alice@example.com", "user_welcome.tmpl", welcomeData)
+	assert.NilError(t, err)
+
+	var entry struct {
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	err = json.NewDecoder(&logs).Decode(&entry)
+	assert.NilError(t, err)
+
+	assert.Equal(t, entry.Message, "email")
+	assert.Equal(t, entry.Properties["to"], "alice@example.com")
+	assert.Equal(t, strings.Contains(entry.Properties["body"], "ABCDEFGHIJKLMNOPQRSTUVWXYZ"), true)
+}
+
+// smtpStandIn accepts a single email on a local port and sends what it
+// received on the returned channel.
+func smtpStandIn(t *testing.T) (host string, port int, received <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost\r\n")
+
+		var data strings.Builder
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case cmd == "DATA":
+				fmt.Fprint(conn, "354 go ahead\r\n")
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+
+					data.WriteString(line)
+				}
+
+				fmt.Fprint(conn, "250 ok\r\n")
+			case cmd == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				ch <- data.String()
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestSMTPMailer(t *testing.T) {
+	host, port, received := smtpStandIn(t)
+
+	m := NewSMTP(host, port, "", "", "Cards <no-reply@cards.local>")
+
+	err := m.Send("alice@example.com", "user_welcome.tmpl", welcomeData)
+	assert.NilError(t, err)
+
+	body := <-received
+
+	assert.Equal(t, strings.Contains(body, "To: alice@example.com"), true)
+	assert.Equal(t, strings.Contains(body, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"), true)
+}
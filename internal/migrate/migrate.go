@@ -0,0 +1,341 @@
+// Package migrate applies the numbered SQL migrations of the API to
+// PostgreSQL. It keeps its state in the schema_migrations table the way
+// golang-migrate does, so databases migrated with the golang-migrate CLI carry
+// on where they left off.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockKey is the PostgreSQL advisory lock held while migrating, so that two
+// servers started with -auto-migrate don't apply the same migration twice.
+const lockKey = 7465302
+
+var (
+	ErrDirty          = errors.New("migrate: database is dirty")
+	ErrUnknownVersion = errors.New("migrate: no migration for the database version")
+)
+
+var filename = regexp.MustCompile(`^([0-9]+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one numbered step of the schema, named after its files such as
+// 000001_create_decks_table.up.sql and 000001_create_decks_table.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%06d_%s", m.Version, m.Name)
+}
+
+// Parse reads the migrations in dir, sorted by version. Every migration must
+// have an up and a down file. Files not named like a migration are ignored.
+func Parse(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		match := filename.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		script, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %s and %s", version, m.Name, match[2])
+		}
+
+		switch match[3] {
+		case "up":
+			m.Up = string(script)
+		case "down":
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		switch {
+		case m.Up == "":
+			return nil, fmt.Errorf("migrate: %s has no up file", m)
+		case m.Down == "":
+			return nil, fmt.Errorf("migrate: %s has no down file", m)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies a set of migrations to a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New parses the migrations in dir for db.
+func New(db *sql.DB, fsys fs.FS, dir string) (*Migrator, error) {
+	migrations, err := Parse(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Latest is the version of the last migration, or 0 when there are none.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version returns the version the database is at and whether the migration
+// to it failed half way. A database that was never migrated is at version 0.
+func (m *Migrator) Version(ctx context.Context) (int, bool, error) {
+	var version int
+	var dirty bool
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, dirty, err = readVersion(ctx, conn)
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// Up applies every migration after the current version, in order, and returns
+// the ones it applied. A database at a version newer than the last migration
+// is left alone.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		for _, migration := range m.Migrations {
+			if migration.Version <= version {
+				continue
+			}
+
+			err := run(ctx, conn, migration.Version, migration.Up)
+			if err != nil {
+				return fmt.Errorf("migrate: %s up: %w", migration, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts up to steps migrations, newest first, and returns the ones it
+// reverted. A steps of 0 or less reverts them all.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		i := m.index(version)
+		if i < 0 && version != 0 {
+			return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+		}
+
+		for ; i >= 0 && (steps <= 0 || len(reverted) < steps); i-- {
+			migration := m.Migrations[i]
+
+			// Like golang-migrate, a failed down migration leaves the
+			// database dirty at the version it was going back to.
+			previous := 0
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+
+			err := run(ctx, conn, previous, migration.Down)
+			if err != nil {
+				return fmt.Errorf("migrate: %s down: %w", migration, err)
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Force records version as the clean current version without running any
+// migration. It is how a dirty database is recovered once it has been fixed
+// by hand.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if version < 0 {
+		return fmt.Errorf("migrate: invalid version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return setVersion(ctx, conn, version, false)
+	})
+}
+
+func (m *Migrator) index(version int) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// withLock runs fn on a single connection holding the migration lock, after
+// making sure the schema_migrations table exists.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`
+
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func readVersion(ctx context.Context, conn *sql.Conn) (int, bool, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	var version int
+	var dirty bool
+
+	err := conn.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// run marks the database dirty at version, runs script and marks it clean.
+// The script is sent as a single statement batch outside of a transaction so
+// that migrations which commit as they go, such as batched backfills, can.
+func run(ctx context.Context, conn *sql.Conn, version int, script string) error {
+	err := setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	return setVersion(ctx, conn, version, false)
+}
+
+func setVersion(ctx context.Context, conn *sql.Conn, version int, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	// A clean database at version 0 has no row, as golang-migrate leaves it.
+	if version > 0 || dirty {
+		query := `
+			INSERT INTO schema_migrations (version, dirty)
+			VALUES ($1, $2)`
+
+		_, err = tx.ExecContext(ctx, query, version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
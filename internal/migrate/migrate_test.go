@@ -0,0 +1,173 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/lib/pq"
+	"github.com/scchi/cards/internal/assert"
+	"github.com/scchi/cards/internal/data"
+	"github.com/scchi/cards/migrations"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Sorts migrations by version and ignores other files", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"api/000010_add_b.up.sql":   {Data: []byte("up b")},
+			"api/000010_add_b.down.sql": {Data: []byte("down b")},
+			"api/000002_add_a.up.sql":   {Data: []byte("up a")},
+			"api/000002_add_a.down.sql": {Data: []byte("down a")},
+			"api/README.md":             {Data: []byte("notes")},
+		}
+
+		got, err := Parse(fsys, "api")
+		assert.NilError(t, err)
+		assert.Equal(t, len(got), 2)
+		assert.Equal(t, got[0], Migration{Version: 2, Name: "add_a", Up: "up a", Down: "down a"})
+		assert.Equal(t, got[1].String(), "000010_add_b")
+	})
+
+	t.Run("Rejects incomplete and clashing migrations", func(t *testing.T) {
+		sets := []fstest.MapFS{
+			{"api/000001_add_a.up.sql": {}},
+			{"api/000001_add_a.down.sql": {}},
+			{
+				"api/000001_add_a.up.sql":   {},
+				"api/000001_add_a.down.sql": {},
+				"api/000001_add_b.up.sql":   {},
+				"api/000001_add_b.down.sql": {},
+			},
+		}
+
+		for _, fsys := range sets {
+			_, err := Parse(fsys, "api")
+			assert.Equal(t, err != nil, true)
+		}
+	})
+
+	t.Run("Embedded migrations end at the schema version of the code", func(t *testing.T) {
+		got, err := Parse(migrations.API, "api")
+		assert.NilError(t, err)
+
+		for i, migration := range got {
+			assert.Equal(t, migration.Version, i+1)
+		}
+
+		assert.Equal(t, (&Migrator{Migrations: got}).Latest(), data.SchemaVersion)
+	})
+}
+
+// TestMigrator runs the embedded migrations against the database named by
+// CARDS_TEST_DB_DSN, which must not hold the schema already.
+func TestMigrator(t *testing.T) {
+	dsn := os.Getenv("CARDS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("CARDS_TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	assert.NilError(t, err)
+	defer db.Close()
+
+	m, err := New(db, migrations.API, "api")
+	assert.NilError(t, err)
+
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(applied), data.SchemaVersion)
+
+	t.Cleanup(func() {
+		_, err := m.Down(ctx, 0)
+		assert.NilError(t, err)
+
+		_, err = db.Exec("DROP TABLE schema_migrations")
+		assert.NilError(t, err)
+	})
+
+	version, dirty, err := m.Version(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, version, data.SchemaVersion)
+	assert.Equal(t, dirty, false)
+
+	health := data.HealthModel{DB: db}
+	version, _, err = health.MigrationVersion(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, version, data.SchemaVersion)
+
+	t.Run("Steps down and back up", func(t *testing.T) {
+		reverted, err := m.Down(ctx, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(reverted), 2)
+
+		version, _, err := m.Version(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, version, data.SchemaVersion-2)
+
+		applied, err := m.Up(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(applied), 2)
+	})
+
+	t.Run("Drops multi-deck shoes when stepping back before deck_count", func(t *testing.T) {
+		_, err := m.Down(ctx, data.SchemaVersion-3)
+		assert.NilError(t, err)
+
+		_, err = db.Exec("INSERT INTO decks (shuffled, deck_count, cards) VALUES (false, 2, ARRAY(SELECT '2S' FROM generate_series(1, 104))), (false, 1, '{2S}')")
+		assert.NilError(t, err)
+
+		_, err = m.Down(ctx, 1)
+		assert.NilError(t, err)
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM decks").Scan(&count)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+
+		_, err = db.Exec("DELETE FROM decks")
+		assert.NilError(t, err)
+
+		_, err = m.Up(ctx)
+		assert.NilError(t, err)
+	})
+
+	t.Run("Legacy decks can't return the cards dealt before migration 5", func(t *testing.T) {
+		_, err := m.Down(ctx, data.SchemaVersion-4)
+		assert.NilError(t, err)
+
+		var id string
+		err = db.QueryRow("INSERT INTO decks (shuffled, cards) VALUES (false, '{2S,3S}') RETURNING id").Scan(&id)
+		assert.NilError(t, err)
+
+		_, err = m.Up(ctx)
+		assert.NilError(t, err)
+
+		decks := data.DeckModel{DB: db}
+
+		_, err = decks.Return(ctx, id, []data.Card{{Rank: data.Ace, Suit: data.Spades}}, "", &data.Event{})
+		assert.Equal(t, errors.Is(err, data.ErrCardsNotFound), true)
+
+		deck, err := decks.Return(ctx, id, nil, "", &data.Event{})
+		assert.NilError(t, err)
+		assert.Equal(t, len(deck.Cards), 2)
+	})
+
+	t.Run("Refuses to migrate a dirty database until forced", func(t *testing.T) {
+		_, err := db.Exec("UPDATE schema_migrations SET dirty = true")
+		assert.NilError(t, err)
+
+		_, err = m.Up(ctx)
+		assert.Equal(t, errors.Is(err, ErrDirty), true)
+
+		err = m.Force(ctx, data.SchemaVersion)
+		assert.NilError(t, err)
+
+		_, err = m.Up(ctx)
+		assert.NilError(t, err)
+	})
+}
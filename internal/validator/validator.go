@@ -1,5 +1,7 @@
 package validator
 
+import "regexp"
+
 type Validator struct {
 	Errors map[string]string
 }
@@ -24,6 +26,10 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
 func PermittedValue[T comparable](value T, permittedValues []T) bool {
 	for i := range permittedValues {
 		if value == permittedValues[i] {
@@ -53,3 +59,16 @@ func Unique[T comparable](values []T) bool {
 
 	return len(values) == len(uniqueValues)
 }
+
+func MaxRepeats[T comparable](values []T, max int) bool {
+	counts := make(map[T]int)
+
+	for _, value := range values {
+		counts[value]++
+		if counts[value] > max {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,7 @@
+DELETE FROM decks WHERE deck_count > 1;
+
+ALTER TABLE decks DROP CONSTRAINT IF EXISTS cards_length_check;
+ALTER TABLE decks ADD CONSTRAINT cards_length_check CHECK (array_length(cards, 1) BETWEEN 0 AND 52);
+
+ALTER TABLE decks DROP CONSTRAINT IF EXISTS deck_count_check;
+ALTER TABLE decks DROP COLUMN IF EXISTS deck_count;
\ No newline at end of file
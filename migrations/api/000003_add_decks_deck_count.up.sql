@@ -0,0 +1,6 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS deck_count integer NOT NULL DEFAULT 1;
+
+ALTER TABLE decks ADD CONSTRAINT deck_count_check CHECK (deck_count BETWEEN 1 AND 8);
+
+ALTER TABLE decks DROP CONSTRAINT IF EXISTS cards_length_check;
+ALTER TABLE decks ADD CONSTRAINT cards_length_check CHECK (array_length(cards, 1) BETWEEN 0 AND 52 * deck_count);
\ No newline at end of file
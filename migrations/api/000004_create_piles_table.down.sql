@@ -0,0 +1 @@
+DROP TABLE IF EXISTS piles;
\ No newline at end of file
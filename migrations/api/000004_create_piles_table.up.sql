@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS piles (
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  name text NOT NULL,
+  cards varchar(3)[] NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1,
+  PRIMARY KEY (deck_id, name)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE decks DROP COLUMN IF EXISTS initial_cards;
\ No newline at end of file
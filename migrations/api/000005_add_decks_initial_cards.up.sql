@@ -0,0 +1,5 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS initial_cards varchar(3)[];
+
+-- Decks created before this migration only know the cards they have left, so
+-- the cards already dealt from them can't be returned.
+UPDATE decks SET initial_cards = cards WHERE initial_cards IS NULL;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE decks DROP COLUMN IF EXISTS shuffle_seed;
+ALTER TABLE decks DROP COLUMN IF EXISTS shuffle_algorithm;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS shuffle_algorithm text NOT NULL DEFAULT '';
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS shuffle_seed bytea;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE decks DROP COLUMN IF EXISTS closed;
+ALTER TABLE decks DROP COLUMN IF EXISTS secret;
+ALTER TABLE decks DROP COLUMN IF EXISTS commitment;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS commitment text NOT NULL DEFAULT '';
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS secret bytea;
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS closed boolean NOT NULL DEFAULT false;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS deck_events;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS deck_events (
+  id bigserial PRIMARY KEY,
+  deck_id uuid NOT NULL,
+  action text NOT NULL,
+  pile text NOT NULL DEFAULT '',
+  cards varchar(3)[] NOT NULL DEFAULT '{}',
+  request_id text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS deck_events_deck_id_idx ON deck_events (deck_id, id);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS deck_draws;
\ No newline at end of file
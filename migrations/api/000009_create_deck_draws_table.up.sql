@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS deck_draws (
+  id bigserial PRIMARY KEY,
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  cards varchar(3)[] NOT NULL,
+  version integer NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS deck_draws_deck_id_idx ON deck_draws (deck_id, id);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS decks_updated_at_idx;
+DROP INDEX IF EXISTS decks_expires_at_idx;
+
+ALTER TABLE decks DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE decks DROP COLUMN IF EXISTS expires_at;
\ No newline at end of file
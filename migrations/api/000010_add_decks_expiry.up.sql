@@ -0,0 +1,5 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS expires_at timestamp(0) with time zone;
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS decks_expires_at_idx ON decks (expires_at);
+CREATE INDEX IF NOT EXISTS decks_updated_at_idx ON decks (updated_at);
\ No newline at end of file
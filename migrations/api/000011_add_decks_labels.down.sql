@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS decks_created_at_idx;
+DROP INDEX IF EXISTS decks_labels_idx;
+
+ALTER TABLE decks DROP COLUMN IF EXISTS labels;
\ No newline at end of file
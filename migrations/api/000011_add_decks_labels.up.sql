@@ -0,0 +1,4 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS decks_labels_idx ON decks USING GIN (labels);
+CREATE INDEX IF NOT EXISTS decks_created_at_idx ON decks (created_at);
\ No newline at end of file
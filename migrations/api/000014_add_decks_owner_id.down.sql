@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS decks_owner_id_idx;
+
+ALTER TABLE decks DROP COLUMN IF EXISTS owner_id;
\ No newline at end of file
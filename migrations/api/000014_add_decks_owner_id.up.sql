@@ -0,0 +1,3 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS owner_id bigint REFERENCES users ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS decks_owner_id_idx ON decks (owner_id);
\ No newline at end of file
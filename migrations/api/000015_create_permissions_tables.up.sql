@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigserial PRIMARY KEY,
+  code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('decks:read'),
+  ('decks:deal'),
+  ('decks:admin');
+
+INSERT INTO users_permissions
+SELECT users.id, permissions.id FROM users CROSS JOIN permissions;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE decks DROP COLUMN IF EXISTS share_version;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS share_version integer NOT NULL DEFAULT 1;
\ No newline at end of file
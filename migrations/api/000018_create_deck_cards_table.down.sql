@@ -0,0 +1,8 @@
+DROP PROCEDURE IF EXISTS backfill_deck_cards(integer);
+DROP TRIGGER IF EXISTS decks_copy_cards ON decks;
+DROP FUNCTION IF EXISTS copy_deck_cards();
+
+ALTER TABLE decks DROP COLUMN IF EXISTS end_position;
+ALTER TABLE decks DROP COLUMN IF EXISTS next_position;
+
+DROP TABLE IF EXISTS deck_cards;
\ No newline at end of file
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS deck_cards (
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  position integer NOT NULL,
+  code varchar(3) NOT NULL,
+  PRIMARY KEY (deck_id, position)
+);
+
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS next_position integer NOT NULL DEFAULT 0;
+ALTER TABLE decks ADD COLUMN IF NOT EXISTS end_position integer NOT NULL DEFAULT 0;
+
+-- Until the cards column is dropped, every write to it, by the previous version
+-- of the API or by the backfill, is copied to deck_cards as the new remaining
+-- cards of the deck.
+CREATE OR REPLACE FUNCTION copy_deck_cards() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO deck_cards (deck_id, position, code)
+  SELECT NEW.id, NEW.end_position + c.n - 1, c.code
+  FROM unnest(NEW.cards) WITH ORDINALITY AS c(code, n);
+
+  UPDATE decks
+  SET next_position = NEW.end_position, end_position = NEW.end_position + coalesce(cardinality(NEW.cards), 0)
+  WHERE id = NEW.id;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS decks_copy_cards ON decks;
+CREATE TRIGGER decks_copy_cards
+AFTER INSERT OR UPDATE OF cards ON decks
+FOR EACH ROW WHEN (NEW.cards IS NOT NULL)
+EXECUTE FUNCTION copy_deck_cards();
+
+-- backfill_deck_cards copies the decks nobody has written to since the trigger
+-- was created, batch_size decks per transaction so that no deck stays locked
+-- for long. It must be called outside a transaction block.
+CREATE OR REPLACE PROCEDURE backfill_deck_cards(batch_size integer) AS $$
+DECLARE
+  last_id uuid := '00000000-0000-0000-0000-000000000000';
+BEGIN
+  LOOP
+    WITH batch AS (
+      SELECT id
+      FROM decks
+      WHERE id > last_id
+      ORDER BY id
+      LIMIT batch_size
+      FOR UPDATE
+    ), copied AS (
+      UPDATE decks
+      SET cards = decks.cards
+      FROM batch
+      WHERE decks.id = batch.id AND decks.end_position = 0 AND cardinality(decks.cards) > 0
+    )
+    SELECT id INTO last_id FROM batch ORDER BY id DESC LIMIT 1;
+
+    EXIT WHEN last_id IS NULL;
+    COMMIT;
+  END LOOP;
+END;
+$$ LANGUAGE plpgsql;
\ No newline at end of file
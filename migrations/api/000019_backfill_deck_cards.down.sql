@@ -0,0 +1 @@
+-- The copied rows go with the deck_cards table when 000018 is rolled back.
\ No newline at end of file
@@ -0,0 +1 @@
+CALL backfill_deck_cards(1000);
\ No newline at end of file
@@ -0,0 +1,8 @@
+DROP PROCEDURE IF EXISTS backfill_deck_cards(integer);
+DROP TRIGGER IF EXISTS decks_copy_cards ON decks;
+DROP FUNCTION IF EXISTS copy_deck_cards();
+
+ALTER TABLE decks DROP CONSTRAINT IF EXISTS cards_length_check;
+ALTER TABLE decks ADD CONSTRAINT cards_length_check CHECK (next_position BETWEEN 0 AND end_position AND end_position - next_position <= 52 * deck_count);
+
+ALTER TABLE decks DROP COLUMN IF EXISTS cards;
\ No newline at end of file
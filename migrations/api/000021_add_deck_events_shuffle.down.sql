@@ -0,0 +1,4 @@
+ALTER TABLE deck_events
+  DROP COLUMN IF EXISTS input_hash,
+  DROP COLUMN IF EXISTS shuffle_seed,
+  DROP COLUMN IF EXISTS shuffle_algorithm;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE deck_events
+  ADD COLUMN IF NOT EXISTS shuffle_algorithm text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS shuffle_seed text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS input_hash text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE deck_events DROP COLUMN IF EXISTS to_pile;
\ No newline at end of file
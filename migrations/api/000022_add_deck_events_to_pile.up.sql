@@ -0,0 +1 @@
+ALTER TABLE deck_events ADD COLUMN IF NOT EXISTS to_pile text NOT NULL DEFAULT '';
\ No newline at end of file
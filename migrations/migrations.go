@@ -0,0 +1,16 @@
+// Package migrations embeds the SQL migrations so the binary can migrate the
+// database without the golang-migrate CLI.
+package migrations
+
+import "embed"
+
+// API holds the numbered up and down migrations of the API schema under api/.
+//
+//go:embed api/*.sql
+var API embed.FS
+
+// Test holds the scripts that create and drop the latest schema in one go for
+// the integration tests, under test/.
+//
+//go:embed test/*.sql
+var Test embed.FS
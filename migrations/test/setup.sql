@@ -1,10 +1,125 @@
+CREATE EXTENSION IF NOT EXISTS citext;
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  email citext UNIQUE NOT NULL,
+  password_hash bytea NOT NULL,
+  activated bool NOT NULL,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+  hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  expiry timestamp(0) with time zone NOT NULL,
+  scope text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigserial PRIMARY KEY,
+  code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('decks:read'),
+  ('decks:deal'),
+  ('decks:admin');
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  name text NOT NULL,
+  hash bytea NOT NULL UNIQUE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+CREATE TABLE IF NOT EXISTS api_keys_permissions (
+  api_key_id bigint NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (api_key_id, permission_id)
+);
+
 CREATE TABLE IF NOT EXISTS decks (
   id uuid DEFAULT uuid_generate_v4 (),
+  owner_id bigint REFERENCES users ON DELETE CASCADE,
   shuffled boolean,
-  cards varchar(3)[],
+  shuffle_algorithm text NOT NULL DEFAULT '',
+  shuffle_seed bytea,
+  commitment text NOT NULL DEFAULT '',
+  secret bytea,
+  closed boolean NOT NULL DEFAULT false,
+  deck_count integer NOT NULL DEFAULT 1,
+  next_position integer NOT NULL DEFAULT 0,
+  end_position integer NOT NULL DEFAULT 0,
+  initial_cards varchar(3)[],
+  labels jsonb NOT NULL DEFAULT '{}',
+  expires_at timestamp(0) with time zone,
   created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
   version integer NOT NULL DEFAULT 1,
+  share_version integer NOT NULL DEFAULT 1,
   PRIMARY KEY (id)
 );
 
-ALTER TABLE decks ADD CONSTRAINT cards_length_check CHECK (array_length(cards, 1) BETWEEN 0 AND 52);
\ No newline at end of file
+ALTER TABLE decks ADD CONSTRAINT deck_count_check CHECK (deck_count BETWEEN 1 AND 8);
+ALTER TABLE decks ADD CONSTRAINT cards_length_check CHECK (next_position BETWEEN 0 AND end_position AND end_position - next_position <= 52 * deck_count);
+
+CREATE TABLE IF NOT EXISTS deck_cards (
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  position integer NOT NULL,
+  code varchar(3) NOT NULL,
+  PRIMARY KEY (deck_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS decks_expires_at_idx ON decks (expires_at);
+CREATE INDEX IF NOT EXISTS decks_updated_at_idx ON decks (updated_at);
+CREATE INDEX IF NOT EXISTS decks_labels_idx ON decks USING GIN (labels);
+CREATE INDEX IF NOT EXISTS decks_created_at_idx ON decks (created_at);
+CREATE INDEX IF NOT EXISTS decks_owner_id_idx ON decks (owner_id);
+
+CREATE TABLE IF NOT EXISTS piles (
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  name text NOT NULL,
+  cards varchar(3)[] NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  version integer NOT NULL DEFAULT 1,
+  PRIMARY KEY (deck_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS deck_events (
+  id bigserial PRIMARY KEY,
+  deck_id uuid NOT NULL,
+  action text NOT NULL,
+  pile text NOT NULL DEFAULT '',
+  cards varchar(3)[] NOT NULL DEFAULT '{}',
+  request_id text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  shuffle_algorithm text NOT NULL DEFAULT '',
+  shuffle_seed text NOT NULL DEFAULT '',
+  input_hash text NOT NULL DEFAULT '',
+  to_pile text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS deck_events_deck_id_idx ON deck_events (deck_id, id);
+
+CREATE TABLE IF NOT EXISTS deck_draws (
+  id bigserial PRIMARY KEY,
+  deck_id uuid NOT NULL REFERENCES decks ON DELETE CASCADE,
+  cards varchar(3)[] NOT NULL,
+  version integer NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS deck_draws_deck_id_idx ON deck_draws (deck_id, id);
\ No newline at end of file
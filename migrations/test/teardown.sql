@@ -1 +1,11 @@
-DROP TABLE IF EXISTS decks;
\ No newline at end of file
+DROP TABLE IF EXISTS deck_draws;
+DROP TABLE IF EXISTS deck_events;
+DROP TABLE IF EXISTS piles;
+DROP TABLE IF EXISTS deck_cards;
+DROP TABLE IF EXISTS decks;
+DROP TABLE IF EXISTS api_keys_permissions;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users;
\ No newline at end of file